@@ -1,10 +1,22 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/insomnes/protohackers/pkg/chat"
+	"github.com/insomnes/protohackers/pkg/chatbot"
+	"github.com/insomnes/protohackers/pkg/limiter"
+	"github.com/insomnes/protohackers/pkg/lineio"
+	"github.com/insomnes/protohackers/pkg/logging"
+	"github.com/insomnes/protohackers/pkg/metrics"
 )
 
 const (
@@ -15,9 +27,132 @@ const (
 func main() {
 	host := flag.String("host", defaultHost, "address to listen on")
 	port := flag.Uint("port", defaultPort, "port to listen on 1-65535")
+	metricsAddr := flag.String("metrics-addr", "", "address to serve /metrics on, disabled if empty")
+	logOpts := logging.AddFlags()
+	limits := limiter.AddFlags()
+	maxMessageSize := flag.Int("max-message-size", chat.DefaultMaxMessageSize, "max message length in bytes")
+	oversizePolicy := lineio.Reject
+	flag.TextVar(&oversizePolicy, "oversize-policy", lineio.Reject, "what to do with longer messages: reject, truncate or disconnect")
+	defaultRoom := flag.String("default-room", chat.DefaultRoom, "room new users join")
+	historySize := flag.Int("history-size", chat.DefaultHistorySize, "recent messages kept per room")
+	historyReplay := flag.Int("history-replay", 0, "recent messages replayed on join, 0 to disable")
+	transcript := flag.String("transcript", "", "file to append room traffic to and reload history from, disabled if empty")
+	sendQueueSize := flag.Int("send-queue-size", chat.DefaultSendQueueSize, "lines queued for a user before the slow policy applies")
+	slowPolicy := chat.SlowDisconnect
+	flag.TextVar(&slowPolicy, "slow-policy", chat.SlowDisconnect, "what to do with users who do not keep up: disconnect, drop-oldest or drop-newest")
+	operators := flag.String("operators", "", "comma-separated registered names that get operator rights when logged in")
+	operPassword := flag.String("oper-password", "", "password for /oper, disabled if empty")
+	banList := flag.String("ban-list", "", "file to keep bans in, in memory only if empty")
+	floodRate := flag.Float64("flood-rate", 0, "lines per second a user may send, 0 disables flood control")
+	floodBurst := flag.Int("flood-burst", 10, "lines a user may send in a burst")
+	floodMaxViolations := flag.Int("flood-max-violations", chat.DefaultFloodMaxViolations, "dropped lines before the flood action is taken")
+	floodAction := chat.FloodMute
+	flag.TextVar(&floodAction, "flood-action", chat.FloodMute, "what to do with users who keep flooding: mute or disconnect")
+	floodMuteFor := flag.Duration("flood-mute-for", chat.DefaultFloodMuteFor, "how long flooding users are muted")
+	webSocket := flag.String("websocket", "", "address to accept WebSocket clients on, disabled if empty")
+	idleAfter := flag.Duration("idle-after", chat.DefaultIdleAfter, "show users without activity for this long as idle, 0 never does")
+	idleTimeout := flag.Duration("idle-timeout", 0, "disconnect users that sent nothing for this long, 0 never does")
+	bots := flag.String("bots", "", "comma-separated bots to run in the default room: time, title")
+	deployFeed := flag.String("deploy-feed", "", "file or FIFO whose lines a deploy bot announces, disabled if empty")
+	irc := flag.String("irc", "", "address to accept IRC clients on, disabled if empty")
+	admin := flag.String("admin", "", "address of the HTTP admin API, disabled if empty")
+	adminToken := flag.String("admin-token", "", "bearer token required by the admin API, which needs one")
+	serverName := flag.String("server-name", "", "name of this server among federated ones, federation is off if empty")
+	federationListen := flag.String("federation-listen", "", "address to accept federation links on, disabled if empty")
+	federationPeers := flag.String("federation-peers", "", "comma-separated addresses of servers to link to")
+	federationSecret := flag.String("federation-secret", "", "secret shared by federated servers")
+	nameMin := flag.Int("name-min-length", chat.DefaultMinNameLength, "shortest name allowed, in characters")
+	nameMax := flag.Int("name-max-length", chat.DefaultMaxNameLength, "longest name allowed, in characters")
+	nameCharset := chat.NameLetters
+	flag.TextVar(&nameCharset, "name-charset", chat.NameLetters, "characters names are made of: letters (any script) or ascii")
+	namePunctuation := flag.String("name-punctuation", "", "other characters allowed in names after the first, e.g. -.")
+	reservedNames := flag.String("reserved-names", strings.Join(chat.DefaultReservedNames, ","), "comma-separated names nobody may take")
+	accounts := flag.String("accounts", "", "file to keep registered names and password hashes in, accounts are disabled if empty")
+	loginPolicy := chat.LoginReject
+	flag.TextVar(&loginPolicy, "login-policy", chat.LoginReject, "what to do with guests taking a registered name without its password: reject or rename")
+	historyMaxAge := flag.Duration("history-max-age", 0, "leave older messages out of replays, 0 for no limit")
 	flag.Parse()
+	if err := logging.Setup(*logOpts); err != nil {
+		log.Fatal("Invalid logging options: ", err)
+	}
 	address := fmt.Sprintf("%s:%d", *host, *port)
-	fmt.Println("address:", address)
-	chatServer := chat.NewChatServer(address)
-	chatServer.Run()
+	slog.Info("Starting chat server", "addr", address)
+	cfg := chat.DefaultConfig()
+	cfg.Address = address
+	cfg.Limits = *limits
+	cfg.MaxMessageSize = *maxMessageSize
+	cfg.OversizePolicy = oversizePolicy
+	cfg.DefaultRoom = *defaultRoom
+	cfg.HistorySize = *historySize
+	cfg.HistoryReplay = *historyReplay
+	cfg.HistoryMaxAge.Duration = *historyMaxAge
+	cfg.Transcript = *transcript
+	cfg.SendQueueSize = *sendQueueSize
+	cfg.SlowPolicy = slowPolicy
+	if *operators != "" {
+		cfg.Operators = strings.Split(*operators, ",")
+	}
+	cfg.OperPassword = *operPassword
+	cfg.BanList = *banList
+	cfg.Flood.Rate = *floodRate
+	cfg.Flood.Burst = *floodBurst
+	cfg.Flood.MaxViolations = *floodMaxViolations
+	cfg.Flood.Action = floodAction
+	cfg.Flood.MuteFor.Duration = *floodMuteFor
+	cfg.WebSocket = *webSocket
+	cfg.IdleAfter.Duration = *idleAfter
+	cfg.IdleTimeout.Duration = *idleTimeout
+	cfg.IRC = *irc
+	cfg.Admin = *admin
+	cfg.AdminToken = *adminToken
+	cfg.Accounts = *accounts
+	cfg.LoginPolicy = loginPolicy
+	cfg.Names.MinLength = *nameMin
+	cfg.Names.MaxLength = *nameMax
+	cfg.Names.Charset = nameCharset
+	cfg.Names.Punctuation = *namePunctuation
+	cfg.Names.Reserved = nil
+	if *reservedNames != "" {
+		cfg.Names.Reserved = strings.Split(*reservedNames, ",")
+	}
+	cfg.Federation.Name = *serverName
+	cfg.Federation.Listen = *federationListen
+	if *federationPeers != "" {
+		cfg.Federation.Peers = strings.Split(*federationPeers, ",")
+	}
+	cfg.Federation.Secret = *federationSecret
+	chatServer := chat.NewChatServer(cfg)
+	if *bots != "" {
+		for _, name := range strings.Split(*bots, ",") {
+			switch name {
+			case "time":
+				chatServer.AddBot(chatbot.NewTimeBot("timebot", nil))
+			case "title":
+				chatServer.AddBot(chatbot.NewTitleBot("titlebot", nil, false))
+			default:
+				log.Fatalf("Unknown bot %q", name)
+			}
+		}
+	}
+	if *deployFeed != "" {
+		chatServer.AddBot(chatbot.NewDeployBot("deploybot", nil, *deployFeed))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *metricsAddr != "" {
+		go serveMetrics(ctx, *metricsAddr)
+	}
+
+	if err := chatServer.Run(ctx); err != nil {
+		slog.Error("Error running server", "err", err)
+		os.Exit(1)
+	}
+}
+
+func serveMetrics(ctx context.Context, addr string) {
+	if err := metrics.Serve(ctx, addr); err != nil {
+		slog.Error("Error serving metrics", "err", err)
+	}
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/insomnes/protohackers/pkg/daemon"
+	"github.com/insomnes/protohackers/pkg/logging"
+)
+
+const defaultConfig = "phd.json"
+
+func main() {
+	configPath := flag.String("config", defaultConfig, "path to the JSON listeners config")
+	flag.Parse()
+
+	cfg, err := daemon.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := logging.Setup(cfg.Log); err != nil {
+		log.Fatal(err)
+	}
+	d, err := daemon.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	d.Run(ctx)
+}
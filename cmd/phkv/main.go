@@ -1,10 +1,19 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/insomnes/protohackers/pkg/config"
 	"github.com/insomnes/protohackers/pkg/kvstore"
+	"github.com/insomnes/protohackers/pkg/logging"
+	"github.com/insomnes/protohackers/pkg/metrics"
 )
 
 const (
@@ -15,9 +24,38 @@ const (
 func main() {
 	host := flag.String("host", defaultHost, "address to listen on")
 	port := flag.Uint("port", defaultPort, "port to listen on 1-65535")
+	writeTimeout := flag.Uint("write-timeout", config.DefaultWriteTimeout, "write timeout")
+	metricsAddr := flag.String("metrics-addr", "", "address to serve /metrics on, disabled if empty")
+	logOpts := logging.AddFlags()
 	flag.Parse()
-	address := fmt.Sprintf("%s:%d", *host, *port)
+	if err := logging.Setup(*logOpts); err != nil {
+		log.Fatal("Invalid logging options: ", err)
+	}
+	if *port < 1 || *port > 65535 {
+		log.Fatalf("Invalid port number: %d", *port)
+	}
 
-	server := kvstore.NewKVServer(address)
-	server.Run()
+	server := kvstore.NewKVServer(config.ServerConfig{
+		Host:         *host,
+		Port:         uint16(*port),
+		WriteTimeout: time.Duration(*writeTimeout) * time.Second,
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *metricsAddr != "" {
+		go serveMetrics(ctx, *metricsAddr)
+	}
+
+	if err := server.Run(ctx); err != nil {
+		slog.Error("Error running server", "err", err)
+		os.Exit(1)
+	}
+}
+
+func serveMetrics(ctx context.Context, addr string) {
+	if err := metrics.Serve(ctx, addr); err != nil {
+		slog.Error("Error serving metrics", "err", err)
+	}
 }
@@ -1,26 +1,62 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/insomnes/protohackers/pkg/limiter"
+	"github.com/insomnes/protohackers/pkg/logging"
+	"github.com/insomnes/protohackers/pkg/metrics"
 	"github.com/insomnes/protohackers/pkg/mitm"
 )
 
 const (
-	defaultChatAddr = "chat.protohackers.com:16963"
-	defaultHost     = "127.0.0.1"
-	defaultPort     = 9999
+	defaultHost = "127.0.0.1"
+	defaultPort = 9999
 )
 
 func main() {
-	chatAddr := flag.String("chat", defaultChatAddr, "chat server address string")
+	chatAddr := flag.String("chat", mitm.DefaultChatAddr, "chat server address string")
 	host := flag.String("host", defaultHost, "address to listen on")
 	port := flag.Uint("port", defaultPort, "port to listen on 1-65535")
+	metricsAddr := flag.String("metrics-addr", "", "address to serve /metrics on, disabled if empty")
+	logOpts := logging.AddFlags()
+	limits := limiter.AddFlags()
+	maxLineSize := flag.Int("max-line-size", mitm.DefaultMaxLineSize, "max line length in bytes")
 	flag.Parse()
+	if err := logging.Setup(*logOpts); err != nil {
+		log.Fatal("Invalid logging options: ", err)
+	}
 	address := fmt.Sprintf("%s:%d", *host, *port)
-	fmt.Println("chat address:", *chatAddr)
-	fmt.Println("address:", address)
-	chatServer := mitm.NewMitmServer(address, *chatAddr)
-	chatServer.Run()
+	slog.Info("Starting mitm server", "addr", address, "chat", *chatAddr)
+	chatServer := mitm.NewMitmServer(mitm.Config{
+		Address:     address,
+		ChatAddr:    *chatAddr,
+		Limits:      *limits,
+		MaxLineSize: *maxLineSize,
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *metricsAddr != "" {
+		go serveMetrics(ctx, *metricsAddr)
+	}
+
+	if err := chatServer.Run(ctx); err != nil {
+		slog.Error("Error running server", "err", err)
+		os.Exit(1)
+	}
+}
+
+func serveMetrics(ctx context.Context, addr string) {
+	if err := metrics.Serve(ctx, addr); err != nil {
+		slog.Error("Error serving metrics", "err", err)
+	}
 }
@@ -1,33 +1,52 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/insomnes/protohackers/pkg/config"
 	"github.com/insomnes/protohackers/pkg/handlers"
+	"github.com/insomnes/protohackers/pkg/logging"
+	"github.com/insomnes/protohackers/pkg/metrics"
 	"github.com/insomnes/protohackers/pkg/server"
 )
 
-var handlerMap = map[string]server.ConnHandler{
-	"echo":  &handlers.EchoHandler{},
-	"prime": &handlers.PrimeHandler{},
-	"means": &handlers.MeansHandler{},
-}
-
 func main() {
+	logOpts := logging.AddFlags()
 	cfg := config.ParseConfig()
-	handler, ok := handlerMap[cfg.Handler]
+	if err := logging.Setup(*logOpts); err != nil {
+		slog.Error("Invalid logging options", "err", err)
+		os.Exit(1)
+	}
+
+	handler, ok := handlers.GetHandler(cfg.Handler)
 	if !ok {
-		fmt.Fprintln(os.Stderr, "Unknown handler:", cfg.Handler)
+		slog.Error("Unknown handler", "handler", cfg.Handler)
 		os.Exit(1)
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.MetricsAddr != "" {
+		go serveMetrics(ctx, cfg.MetricsAddr)
+	}
+
+	handler = server.Chain(handler, server.DefaultMiddlewares(cfg)...)
 	server := server.NewServer(cfg, handler)
 
-	if err := server.Run(); err != nil {
-		fmt.Fprintln(os.Stderr, "Error running server:", err)
+	if err := server.Run(ctx); err != nil {
+		slog.Error("Error running server", "err", err)
 		os.Exit(1)
 	}
-	fmt.Println("Server stopped")
+	slog.Info("Server stopped")
+}
+
+func serveMetrics(ctx context.Context, addr string) {
+	if err := metrics.Serve(ctx, addr); err != nil {
+		slog.Error("Error serving metrics", "err", err)
+	}
 }
@@ -1,3 +1,3 @@
 module github.com/insomnes/protohackers
 
-go 1.23.2
+go 1.24
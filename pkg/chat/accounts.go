@@ -0,0 +1,295 @@
+package chat
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	mathrand "math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/insomnes/protohackers/pkg/metrics"
+)
+
+const (
+	// accountKDF names the only password hash in use, so stored hashes
+	// can be told apart should it ever change.
+	accountKDF = "pbkdf2-sha256"
+	// DefaultKDFIterations follows current advice for PBKDF2-HMAC-SHA256.
+	DefaultKDFIterations = 600_000
+	accountSaltSize      = 16
+	accountHashSize      = 32
+	minPasswordLength    = 8
+	guestNameTries       = 10
+)
+
+var (
+	errAccountsDisabled  = errors.New("accounts are disabled on this server")
+	errAlreadyRegistered = errors.New("name is already registered")
+	errPasswordTooShort  = fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	errNameRegistered    = errors.New("name is registered, connect with its password to use it")
+
+	logins = metrics.Default.Counter(
+		"protohackers_chat_logins_total",
+		"Guests taking registered names, by whether the password was right.",
+		"result",
+	)
+)
+
+// LoginPolicy decides what happens to guests that take a registered name
+// without its password.
+type LoginPolicy int
+
+const (
+	// LoginReject turns the guest away.
+	LoginReject LoginPolicy = iota
+	// LoginRename lets the guest in under a generated name.
+	LoginRename
+)
+
+func (p LoginPolicy) String() string {
+	return [...]string{"reject", "rename"}[p]
+}
+
+func ParseLoginPolicy(s string) (LoginPolicy, error) {
+	switch strings.ToLower(s) {
+	case "reject":
+		return LoginReject, nil
+	case "rename":
+		return LoginRename, nil
+	}
+	return 0, fmt.Errorf("unknown login policy %q", s)
+}
+
+func (p LoginPolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *LoginPolicy) UnmarshalText(b []byte) error {
+	parsed, err := ParseLoginPolicy(string(b))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// Account is a registered name and the hash of its password.
+type Account struct {
+	Name       string    `json:"name"`
+	KDF        string    `json:"kdf"`
+	Iterations int       `json:"iterations"`
+	Salt       []byte    `json:"salt"`
+	Hash       []byte    `json:"hash"`
+	Created    time.Time `json:"created"`
+}
+
+// AccountStore keeps accounts in a file, saved on every change. A nil
+// store has no accounts and refuses registrations, which leaves every
+// name free to take.
+type AccountStore struct {
+	path string
+	// iterations is the KDF cost for new passwords.
+	iterations int
+	// hashing holds a slot per password being hashed, at most one per CPU,
+	// so guests trying passwords cannot starve the rest of the server.
+	hashing chan struct{}
+
+	mu       sync.Mutex
+	accounts map[string]Account
+}
+
+// LoadAccounts reads the accounts saved at path. A missing file has no
+// accounts yet.
+func LoadAccounts(path string) (*AccountStore, error) {
+	as := &AccountStore{
+		path:       path,
+		iterations: DefaultKDFIterations,
+		hashing:    make(chan struct{}, runtime.NumCPU()),
+		accounts:   make(map[string]Account),
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return as, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read accounts: %w", err)
+	}
+	var accounts []Account
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return nil, fmt.Errorf("failed to parse accounts %s: %w", path, err)
+	}
+	for _, account := range accounts {
+		if account.KDF != accountKDF {
+			return nil, fmt.Errorf("account %s: unknown kdf %q", account.Name, account.KDF)
+		}
+		as.accounts[nameKey(account.Name)] = account
+	}
+	return as, nil
+}
+
+func (as *AccountStore) Registered(name string) bool {
+	if as == nil {
+		return false
+	}
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	_, ok := as.accounts[nameKey(name)]
+	return ok
+}
+
+// Verify reports whether password is the one name was registered with.
+// It is slow on purpose and must not run on the Hub event loop.
+func (as *AccountStore) Verify(name, password string) bool {
+	if as == nil {
+		return false
+	}
+	as.mu.Lock()
+	account, ok := as.accounts[nameKey(name)]
+	as.mu.Unlock()
+	if !ok {
+		return false
+	}
+	hash, err := as.hash(password, account.Salt, account.Iterations, len(account.Hash))
+	return err == nil && subtle.ConstantTimeCompare(hash, account.Hash) == 1
+}
+
+// Register creates an account for name. Like Verify it is slow.
+func (as *AccountStore) Register(name, password string) error {
+	if as == nil {
+		return errAccountsDisabled
+	}
+	if len([]rune(password)) < minPasswordLength {
+		return errPasswordTooShort
+	}
+	salt := make([]byte, accountSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	hash, err := as.hash(password, salt, as.iterations, accountHashSize)
+	if err != nil {
+		return err
+	}
+	account := Account{
+		Name:       name,
+		KDF:        accountKDF,
+		Iterations: as.iterations,
+		Salt:       salt,
+		Hash:       hash,
+		Created:    time.Now().UTC(),
+	}
+
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	key := nameKey(name)
+	if _, ok := as.accounts[key]; ok {
+		return errAlreadyRegistered
+	}
+	as.accounts[key] = account
+	if err := as.save(); err != nil {
+		delete(as.accounts, key)
+		return err
+	}
+	return nil
+}
+
+// save writes the accounts through a temporary file, which is only
+// readable by the owner. The caller holds mu.
+func (as *AccountStore) save() error {
+	accounts := make([]Account, 0, len(as.accounts))
+	for _, account := range as.accounts {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Name < accounts[j].Name })
+
+	data, err := json.MarshalIndent(accounts, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(as.path), filepath.Base(as.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save accounts: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save accounts: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save accounts: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), as.path); err != nil {
+		return fmt.Errorf("failed to save accounts: %w", err)
+	}
+	return nil
+}
+
+func (as *AccountStore) hash(password string, salt []byte, iterations, keyLen int) ([]byte, error) {
+	as.hashing <- struct{}{}
+	defer func() { <-as.hashing }()
+	return pbkdf2.Key(sha256.New, password, salt, iterations, keyLen)
+}
+
+type accountRegistered struct {
+	user *User
+	name string
+	err  error
+}
+
+func (r accountRegistered) handle(h *Hub) {
+	if !h.present(r.user) {
+		return
+	}
+	if r.err != nil {
+		r.user.logger.Warn("Registration failed", "name", r.name, "err", r.err)
+		h.reply(r.user, "Error: cannot register %s: %v", r.name, r.err)
+		return
+	}
+	r.user.account = r.name
+	r.user.logger.Info("Name registered", "name", r.name)
+	h.reply(r.user, "Registered %s, you will be asked for the password when you connect with it", r.name)
+}
+
+// login checks the password of a guest that picked a registered name. It
+// returns the name to join with, which under LoginRename is a generated
+// one when the password is wrong, or false once the guest is rejected.
+func (g *Guest) login(name, password string) (string, bool) {
+	if g.accounts.Verify(name, password) {
+		logins.With("ok").Inc()
+		g.account = name
+		return name, true
+	}
+	logins.With("failed").Inc()
+	g.logger.Warn("Wrong password for registered name", "name", name)
+	if g.loginPolicy == LoginRename {
+		if renamed, ok := g.guestName(); ok {
+			return renamed, true
+		}
+	}
+	g.Reject(fmt.Sprintf("Wrong password for %s", name))
+	return "", false
+}
+
+// guestName finds a free name like Guest1234 that passes the name policy.
+func (g *Guest) guestName() (string, bool) {
+	for range guestNameTries {
+		name, err := g.names.check(fmt.Sprintf("Guest%04d", mathrand.IntN(10000)))
+		if err != nil {
+			return "", false
+		}
+		if !g.nameTaken(name) && !g.accounts.Registered(name) {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,111 @@
+package chat
+
+import (
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestPBKDF2 tests the key derivation against the RFC 7914 test vectors
+// and that it is bounded to a hash per CPU
+func TestPBKDF2(t *testing.T) {
+	store, err := LoadAccounts(filepath.Join(t.TempDir(), "accounts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		password, salt string
+		iterations     int
+		want           string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+			"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56" +
+			"a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+	for _, tt := range tests {
+		hash, err := store.hash(tt.password, []byte(tt.salt), tt.iterations, 64)
+		if got := hex.EncodeToString(hash); err != nil || got != tt.want {
+			t.Errorf("Expected %s for %s/%s, got %s (%v)", tt.want, tt.password, tt.salt, got, err)
+		}
+	}
+
+	// With every slot taken, hashing waits for one to free up.
+	for range cap(store.hashing) {
+		store.hashing <- struct{}{}
+	}
+	done := make(chan struct{})
+	go func() {
+		store.hash("passwd", []byte("salt"), 1, 32)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Errorf("Expected hashing to wait for a free slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-store.hashing
+	<-done
+}
+
+// TestAccountStore tests that registered names survive a reload and only
+// take their own password
+func TestAccountStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+	store, err := LoadAccounts(path)
+	if err != nil {
+		t.Fatalf("Expected empty store, got %v", err)
+	}
+	store.iterations = 1
+	if err := store.Register("alice", "short"); err != errPasswordTooShort {
+		t.Errorf("Expected %v, got %v", errPasswordTooShort, err)
+	}
+	if err := store.Register("alice", "correct horse"); err != nil {
+		t.Fatalf("Expected alice to register, got %v", err)
+	}
+	if err := store.Register("ALICE", "another one"); err != errAlreadyRegistered {
+		t.Errorf("Expected %v, got %v", errAlreadyRegistered, err)
+	}
+
+	reloaded, err := LoadAccounts(path)
+	if err != nil {
+		t.Fatalf("Expected store to reload, got %v", err)
+	}
+	if !reloaded.Registered("Alice") {
+		t.Errorf("Expected Alice to be registered")
+	}
+	if !reloaded.Verify("alice", "correct horse") {
+		t.Errorf("Expected the right password to verify")
+	}
+	if reloaded.Verify("alice", "wrong horse") {
+		t.Errorf("Expected a wrong password to fail")
+	}
+	var disabled *AccountStore
+	if disabled.Registered("alice") || disabled.Register("alice", "correct horse") != errAccountsDisabled {
+		t.Errorf("Expected a nil store to have no accounts")
+	}
+}
+
+// TestIsOperator tests that listed names only get operator rights once
+// logged in to their account
+func TestIsOperator(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Operators = []string{"Alice"}
+	hub := NewHub(cfg, nil, nil, nil)
+	tests := []struct {
+		name, account string
+		want          bool
+	}{
+		{"alice", "", false},
+		{"alice", "alice", true},
+		{"bob", "bob", false},
+		{"alice", "bob", false},
+	}
+	for _, tt := range tests {
+		user := &User{Name: tt.name, account: tt.account}
+		if got := hub.isOperator(user); got != tt.want {
+			t.Errorf("Expected %v for %s with account %q, got %v", tt.want, tt.name, tt.account, got)
+		}
+	}
+}
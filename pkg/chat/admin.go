@@ -0,0 +1,366 @@
+package chat
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/insomnes/protohackers/pkg/config"
+)
+
+// adminName signs kicks and bans made through the admin API.
+const adminName = "admin"
+
+const maxAdminBody = 64 << 10
+
+var (
+	errHubStopped = errors.New("chat hub stopped")
+	errNoSuchRoom = errors.New("no such room")
+)
+
+type RoomInfo struct {
+	Name  string   `json:"name"`
+	Users []string `json:"users"`
+}
+
+type UserInfo struct {
+	Name        string    `json:"name"`
+	Address     string    `json:"address"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Rooms       []string  `json:"rooms"`
+	Oper        bool      `json:"oper"`
+	Muted       bool      `json:"muted"`
+	// Presence is "away", "away: <message>" or "idle", empty if active.
+	Presence   string    `json:"presence,omitempty"`
+	LastActive time.Time `json:"last_active"`
+	Sent       int       `json:"messages_sent"`
+	Received   int       `json:"messages_received"`
+}
+
+type roomsQuery struct {
+	reply chan []RoomInfo
+}
+
+func (q roomsQuery) handle(h *Hub) {
+	rooms := make([]RoomInfo, 0, len(h.rooms))
+	for _, name := range roomNames(h.rooms) {
+		rooms = append(rooms, RoomInfo{Name: name, Users: h.rooms[name].names().Names})
+	}
+	q.reply <- rooms
+}
+
+// Rooms lists the rooms and their members.
+func (h *Hub) Rooms() ([]RoomInfo, error) {
+	q := roomsQuery{reply: make(chan []RoomInfo, 1)}
+	rooms, ok := ask(h, q, q.reply)
+	if !ok {
+		return nil, errHubStopped
+	}
+	return rooms, nil
+}
+
+type usersQuery struct {
+	reply chan []UserInfo
+}
+
+func (q usersQuery) handle(h *Hub) {
+	now := time.Now()
+	users := make([]UserInfo, 0, len(h.users))
+	for _, user := range h.users {
+		users = append(users, UserInfo{
+			Name:        user.Name,
+			Address:     user.Address,
+			ConnectedAt: user.connectedAt,
+			Rooms:       roomNames(user.rooms),
+			Oper:        user.oper,
+			Muted:       user.isMuted(now),
+			Presence:    user.presence(now),
+			LastActive:  user.lastActive,
+			Sent:        user.sent,
+			Received:    user.received,
+		})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	q.reply <- users
+}
+
+// Users lists the connected users.
+func (h *Hub) Users() ([]UserInfo, error) {
+	q := usersQuery{reply: make(chan []UserInfo, 1)}
+	users, ok := ask(h, q, q.reply)
+	if !ok {
+		return nil, errHubStopped
+	}
+	return users, nil
+}
+
+type broadcastRequest struct {
+	room  string
+	text  string
+	reply chan error
+}
+
+func (r broadcastRequest) handle(h *Hub) {
+	names := roomNames(h.rooms)
+	if r.room != "" {
+		name, err := normalizeRoomName(r.room)
+		if _, ok := h.rooms[name]; err != nil || !ok {
+			r.reply <- errNoSuchRoom
+			return
+		}
+		names = []string{name}
+	}
+	for _, name := range names {
+		h.rooms[name].handleMessage(notice("%s", sanitizeText(r.text)))
+	}
+	r.reply <- nil
+}
+
+// Broadcast sends a system notice to a room, or to every room when room
+// is empty.
+func (h *Hub) Broadcast(room, text string) error {
+	r := broadcastRequest{room: room, text: text, reply: make(chan error, 1)}
+	err, ok := ask(h, r, r.reply)
+	if !ok {
+		return errHubStopped
+	}
+	return err
+}
+
+type kickRequest struct {
+	name   string
+	reason string
+	reply  chan error
+}
+
+func (r kickRequest) handle(h *Hub) {
+	target, ok := h.lookup(r.name)
+	if !ok || target.remote != nil {
+		r.reply <- errNoSuchUser
+		return
+	}
+	h.kickBy(target, adminName, sanitizeText(r.reason))
+	r.reply <- nil
+}
+
+// Kick disconnects the named user.
+func (h *Hub) Kick(name, reason string) error {
+	r := kickRequest{name: name, reason: reason, reply: make(chan error, 1)}
+	err, ok := ask(h, r, r.reply)
+	if !ok {
+		return errHubStopped
+	}
+	return err
+}
+
+type banResult struct {
+	kicked []string
+	err    error
+}
+
+type banRequest struct {
+	ban   Ban
+	reply chan banResult
+}
+
+func (r banRequest) handle(h *Hub) {
+	err := h.bans.Add(r.ban)
+	if err != nil {
+		slog.Error("Failed to save ban", "ban", r.ban.String(), "by", r.ban.By, "err", err)
+	} else {
+		slog.Info("Ban added", "ban", r.ban.String(), "by", r.ban.By)
+	}
+	r.reply <- banResult{kicked: h.enforceBan(r.ban, nil), err: err}
+}
+
+// Ban adds the ban and kicks the users it applies to. The ban is in force
+// even when saving the ban list fails.
+func (h *Hub) Ban(ban Ban) ([]string, error) {
+	r := banRequest{ban: ban, reply: make(chan banResult, 1)}
+	res, ok := ask(h, r, r.reply)
+	if !ok {
+		return nil, errHubStopped
+	}
+	return res.kicked, res.err
+}
+
+type adminAPI struct {
+	hub   *Hub
+	bans  *BanList
+	token string
+}
+
+// newAdminHandler serves the admin API to requests bearing token.
+func newAdminHandler(hub *Hub, bans *BanList, token string) http.Handler {
+	api := &adminAPI{hub: hub, bans: bans, token: token}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rooms", api.listRooms)
+	mux.HandleFunc("GET /users", api.listUsers)
+	mux.HandleFunc("POST /users/{name}/kick", api.kick)
+	mux.HandleFunc("POST /broadcast", api.broadcast)
+	mux.HandleFunc("GET /bans", api.listBans)
+	mux.HandleFunc("POST /bans", api.ban)
+	mux.HandleFunc("DELETE /bans/{target}", api.unban)
+	return api.authorize(mux)
+}
+
+func (api *adminAPI) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if api.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(api.token)) != 1 {
+			slog.Warn("Unauthorized admin request", "remote", r.RemoteAddr, "path", r.URL.Path)
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (api *adminAPI) listRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := api.hub.Rooms()
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rooms)
+}
+
+func (api *adminAPI) listUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := api.hub.Users()
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+func (api *adminAPI) kick(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 && !readJSON(w, r, &req) {
+		return
+	}
+	switch err := api.hub.Kick(r.PathValue("name"), req.Reason); {
+	case errors.Is(err, errNoSuchUser):
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusServiceUnavailable, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (api *adminAPI) broadcast(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Room string `json:"room"`
+		Text string `json:"text"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Text == "" || strings.ContainsAny(req.Text, "\r\n") {
+		writeError(w, http.StatusBadRequest, errors.New("text must be a single non-empty line"))
+		return
+	}
+	switch err := api.hub.Broadcast(req.Room, req.Text); {
+	case errors.Is(err, errNoSuchRoom):
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusServiceUnavailable, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (api *adminAPI) listBans(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, api.bans.List(time.Now()))
+}
+
+func (api *adminAPI) ban(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Target   string          `json:"target"`
+		Duration config.Duration `json:"duration"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Target == "" || req.Duration.Duration < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("target is required and duration cannot be negative"))
+		return
+	}
+	now := time.Now()
+	ban := Ban{Target: req.Target, By: adminName, Since: now}
+	if req.Duration.Duration > 0 {
+		ban.Until = now.Add(req.Duration.Duration)
+	}
+	kicked, err := api.hub.Ban(ban)
+	if errors.Is(err, errHubStopped) {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("ban is active but could not be saved: %w", err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, struct {
+		Ban    Ban      `json:"ban"`
+		Kicked []string `json:"kicked"`
+	}{ban, kicked})
+}
+
+func (api *adminAPI) unban(w http.ResponseWriter, r *http.Request) {
+	removed, err := api.bans.Remove(r.PathValue("target"))
+	switch {
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("ban is lifted but could not be saved: %w", err))
+	case !removed:
+		writeError(w, http.StatusNotFound, errors.New("no such ban"))
+	default:
+		slog.Info("Ban lifted", "target", r.PathValue("target"), "by", adminName)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// readJSON decodes the request body into v, answering the request itself
+// when that fails.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error writing admin response", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+// serveAdmin runs the admin API on ln. It returns once srv is closed.
+func serveAdmin(srv *http.Server, ln net.Listener) error {
+	slog.Info("Serving chat admin API", "addr", ln.Addr().String())
+	err := srv.Serve(ln)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return fmt.Errorf("admin listener: %w", err)
+}
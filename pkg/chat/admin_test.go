@@ -0,0 +1,68 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestAdminRooms tests the token check and the room listing through a running Hub
+func TestAdminRooms(t *testing.T) {
+	bans, _ := LoadBanList("")
+	hub := NewHub(DefaultConfig(), nil, bans, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hub.Run(ctx)
+	handler := newAdminHandler(hub, bans, "secret")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/rooms", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without a token, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/rooms", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	var rooms []RoomInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &rooms); err != nil {
+		t.Fatalf("Expected a room list, got %q: %v", rec.Body.String(), err)
+	}
+	if len(rooms) != 1 || rooms[0].Name != DefaultRoom || len(rooms[0].Users) != 0 {
+		t.Errorf("Expected the empty default room, got %+v", rooms)
+	}
+
+	open := newAdminHandler(hub, bans, "")
+	req = httptest.NewRequest("POST", "/broadcast", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rec = httptest.NewRecorder()
+	open.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without a configured token, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+// TestHubStopped tests that requests made as the Hub stops fail instead
+// of waiting for a reply forever
+func TestHubStopped(t *testing.T) {
+	bans, _ := LoadBanList("")
+	hub := NewHub(DefaultConfig(), nil, bans, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	hub.Run(ctx)
+
+	for range EventChannelSize + 1 {
+		if _, err := hub.Rooms(); err != errHubStopped {
+			t.Fatalf("Expected %v, got %v", errHubStopped, err)
+		}
+	}
+	if err := hub.AddBot(ctx, &testBot{name: "late"}); err != errHubStopped {
+		t.Errorf("Expected %v, got %v", errHubStopped, err)
+	}
+	if !hub.NameTaken("alice") {
+		t.Errorf("Expected every name to be taken once stopped")
+	}
+}
@@ -0,0 +1,170 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Ban struct {
+	// Target is a user name or an IP address.
+	Target string    `json:"target"`
+	By     string    `json:"by,omitempty"`
+	Since  time.Time `json:"since"`
+	// Until is zero for permanent bans.
+	Until time.Time `json:"until,omitempty"`
+}
+
+func (b Ban) IsIP() bool {
+	return net.ParseIP(b.Target) != nil
+}
+
+func (b Ban) Expired(now time.Time) bool {
+	return !b.Until.IsZero() && now.After(b.Until)
+}
+
+func (b Ban) String() string {
+	if b.Until.IsZero() {
+		return fmt.Sprintf("%s (permanent)", b.Target)
+	}
+	return fmt.Sprintf("%s (until %s)", b.Target, b.Until.Format(time.DateTime))
+}
+
+// BanList is shared by the Butler, which checks it, and the Hub, which
+// changes it. When it has a path every change is saved there.
+type BanList struct {
+	path string
+
+	mu   sync.Mutex
+	bans map[string]Ban
+}
+
+// banKey is what bans are kept by, so name bans match names the way
+// users are told apart.
+func banKey(target string) string {
+	if net.ParseIP(target) != nil {
+		return target
+	}
+	return nameKey(target)
+}
+
+// LoadBanList reads the bans saved at path. An empty path keeps bans in
+// memory only and a missing file is an empty list.
+func LoadBanList(path string) (*BanList, error) {
+	bl := &BanList{path: path, bans: make(map[string]Ban)}
+	if path == "" {
+		return bl, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return bl, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ban list: %w", err)
+	}
+	var bans []Ban
+	if err := json.Unmarshal(data, &bans); err != nil {
+		return nil, fmt.Errorf("failed to parse ban list %s: %w", path, err)
+	}
+	for _, ban := range bans {
+		bl.bans[banKey(ban.Target)] = ban
+	}
+	return bl, nil
+}
+
+// Check returns the active ban on target, if any.
+func (bl *BanList) Check(target string, now time.Time) (Ban, bool) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	ban, ok := bl.bans[banKey(target)]
+	if !ok || ban.Expired(now) {
+		return Ban{}, false
+	}
+	return ban, true
+}
+
+func (bl *BanList) Add(ban Ban) error {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.bans[banKey(ban.Target)] = ban
+	return bl.save()
+}
+
+// Remove reports whether target was banned.
+func (bl *BanList) Remove(target string) (bool, error) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	if _, ok := bl.bans[banKey(target)]; !ok {
+		return false, nil
+	}
+	delete(bl.bans, banKey(target))
+	return true, bl.save()
+}
+
+// List returns the active bans sorted by target.
+func (bl *BanList) List(now time.Time) []Ban {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bans := make([]Ban, 0, len(bl.bans))
+	for _, ban := range bl.bans {
+		if !ban.Expired(now) {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Target < bans[j].Target })
+	return bans
+}
+
+// save writes the list through a temporary file, dropping expired bans.
+// The caller holds mu.
+func (bl *BanList) save() error {
+	if bl.path == "" {
+		return nil
+	}
+	now := time.Now()
+	bans := make([]Ban, 0, len(bl.bans))
+	for target, ban := range bl.bans {
+		if ban.Expired(now) {
+			delete(bl.bans, target)
+			continue
+		}
+		bans = append(bans, ban)
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Target < bans[j].Target })
+
+	data, err := json.MarshalIndent(bans, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(bl.path), filepath.Base(bl.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save ban list: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save ban list: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save ban list: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), bl.path); err != nil {
+		return fmt.Errorf("failed to save ban list: %w", err)
+	}
+	return nil
+}
+
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
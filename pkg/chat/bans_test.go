@@ -0,0 +1,43 @@
+package chat
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestBanListPersists tests that bans survive a reload and expired ones do not apply
+func TestBanListPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+	bl, err := LoadBanList(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	now := time.Now()
+	bl.Add(Ban{Target: "bob", Since: now})
+	bl.Add(Ban{Target: "10.0.0.1", Since: now, Until: now.Add(time.Hour)})
+	bl.Add(Ban{Target: "eve", Since: now, Until: now.Add(-time.Minute)})
+
+	reloaded, err := LoadBanList(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, target := range []string{"bob", "10.0.0.1"} {
+		if _, banned := reloaded.Check(target, now); !banned {
+			t.Errorf("Expected %s to be banned", target)
+		}
+	}
+	if _, banned := reloaded.Check("eve", now); banned {
+		t.Errorf("Expected expired ban on eve to be ignored")
+	}
+	if _, banned := reloaded.Check("10.0.0.1", now.Add(2*time.Hour)); banned {
+		t.Errorf("Expected ban on 10.0.0.1 to expire")
+	}
+
+	if removed, _ := reloaded.Remove("bob"); !removed {
+		t.Errorf("Expected bob to be unbanned")
+	}
+	if _, banned := reloaded.Check("bob", now); banned {
+		t.Errorf("Expected bob not to be banned after Remove")
+	}
+}
@@ -0,0 +1,268 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/insomnes/protohackers/pkg/logging"
+	"github.com/insomnes/protohackers/pkg/metrics"
+)
+
+// botEventQueueSize bounds the events waiting for a bot; a bot that falls
+// further behind loses events rather than slowing the Hub down.
+const botEventQueueSize = 256
+
+var botErrors = metrics.Default.Counter(
+	"protohackers_chat_bot_errors_total",
+	"Chat bot panics and events dropped for bots that fell behind.",
+	"kind",
+)
+
+// Bot is an in-process chat participant. It is listed in its rooms like
+// any user, but has no connection.
+type Bot interface {
+	// Name is the bot's user name, validated like any other.
+	Name() string
+	// Rooms are the rooms the bot joins, the default room if empty.
+	Rooms() []string
+	// Handle is called with every chat, action, private, join, part and
+	// nick message the bot sees, one at a time on the bot's goroutine.
+	// Panics are recovered and logged.
+	Handle(c *BotClient, m Message)
+}
+
+// BotRunner is a Bot that also acts on its own, e.g. announcing events
+// from a file. Run is started once the bot has joined its rooms and
+// should return when ctx is done, which happens when the server stops or
+// the bot is kicked.
+type BotRunner interface {
+	Bot
+	Run(ctx context.Context, c *BotClient)
+}
+
+// BotClient lets a bot talk. It is safe for use from any goroutine.
+type BotClient struct {
+	name   string
+	hub    *Hub
+	user   *User
+	events chan Message
+	logger *slog.Logger
+}
+
+func (c *BotClient) Name() string {
+	return c.name
+}
+
+// Say sends text to a room the bot is in, or to all of them when room is
+// empty. Every line of text is a message of its own.
+func (c *BotClient) Say(room, text string) {
+	c.hub.do(botSay{user: c.user, kind: ChatMessage, target: room, text: text})
+}
+
+// Act sends text to a room as an action, like /me.
+func (c *BotClient) Act(room, text string) {
+	c.hub.do(botSay{user: c.user, kind: ActionMessage, target: room, text: text})
+}
+
+// Msg sends text to a user privately.
+func (c *BotClient) Msg(to, text string) {
+	c.hub.do(botSay{user: c.user, kind: PrivateMessage, target: to, text: text})
+}
+
+// Reply answers m where it came from: privately or in its room.
+func (c *BotClient) Reply(m Message, text string) {
+	if m.Kind == PrivateMessage {
+		c.Msg(m.From, text)
+		return
+	}
+	c.Say(m.Room, text)
+}
+
+func (c *BotClient) Logger() *slog.Logger {
+	return c.logger
+}
+
+// safely runs f, turning a panic into a log line.
+func (c *BotClient) safely(what string, f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			botErrors.With("panic").Inc()
+			c.logger.Error("Bot panicked", "in", what, "panic", r)
+		}
+	}()
+	f()
+}
+
+// botCodec hands messages to the bot instead of rendering them.
+type botCodec struct {
+	events chan Message
+	logger *slog.Logger
+}
+
+func (botCodec) handle(*Hub, *User, string) {}
+
+func (c botCodec) format(user *User, m Message) []string {
+	if m.History || m.Kind == NamesMessage || (m.Kind == ChatMessage && m.From == "") {
+		return nil
+	}
+	select {
+	case c.events <- m:
+	default:
+		botErrors.With("dropped").Inc()
+		c.logger.Warn("Bot is behind, dropping event", "kind", m.Kind.String())
+	}
+	return nil
+}
+
+func (botCodec) reject(reason string) string {
+	return reason
+}
+
+type botJoin struct {
+	bot   Bot
+	reply chan botJoined
+}
+
+type botJoined struct {
+	client *BotClient
+	err    error
+}
+
+func (r botJoin) handle(h *Hub) {
+	name, err := h.cfg.Names.check(r.bot.Name())
+	if err != nil {
+		r.reply <- botJoined{err: fmt.Errorf("invalid bot name %s: %w", r.bot.Name(), err)}
+		return
+	}
+	if _, taken := h.lookup(name); taken {
+		r.reply <- botJoined{err: fmt.Errorf("bot name %s: %w", name, errNameTaken)}
+		return
+	}
+	rooms := []string{h.defaultRoom}
+	if len(r.bot.Rooms()) > 0 {
+		rooms = rooms[:0]
+		for _, room := range r.bot.Rooms() {
+			normalized, err := normalizeRoomName(room)
+			if err != nil {
+				r.reply <- botJoined{err: fmt.Errorf("bot %s room %s: %w", name, room, err)}
+				return
+			}
+			rooms = append(rooms, normalized)
+		}
+	}
+
+	client := newBotClient(h, name)
+	h.users[nameKey(name)] = client.user
+	for _, room := range rooms {
+		h.joinRoom(client.user, room)
+	}
+	client.logger.Info("Bot joined", "rooms", rooms)
+	r.reply <- botJoined{client: client}
+}
+
+func newBotClient(h *Hub, name string) *BotClient {
+	id := logging.NewConnID()
+	logger := logging.ForConn(slog.Default(), id, "bot").With("name", name)
+	events := make(chan Message, botEventQueueSize)
+	now := time.Now()
+	user := &User{
+		ID:      id,
+		Address: "bot",
+		Name:    name,
+		codec:   botCodec{events: events, logger: logger},
+		queue:   newSendQueue(h.cfg.SendQueueSize, SlowDropOldest),
+		quit:    make(chan struct{}),
+		slow:    make(chan struct{}),
+		rooms:   make(map[string]*ChatRoom),
+		logger:  logger,
+		bot:     true,
+
+		connectedAt: now,
+		lastSeen:    now,
+		lastActive:  now,
+	}
+	return &BotClient{name: name, hub: h, user: user, events: events, logger: logger}
+}
+
+// AddBot joins the bot to its rooms and runs it until ctx is done or the
+// bot is kicked.
+func (h *Hub) AddBot(ctx context.Context, bot Bot) error {
+	r := botJoin{bot: bot, reply: make(chan botJoined, 1)}
+	joined, ok := ask(h, r, r.reply)
+	if !ok {
+		return errHubStopped
+	}
+	if joined.err != nil {
+		return joined.err
+	}
+	go joined.client.run(ctx, bot)
+	return nil
+}
+
+func (c *BotClient) run(ctx context.Context, bot Bot) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if runner, ok := bot.(BotRunner); ok {
+		go c.safely("run", func() { runner.Run(ctx, c) })
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.user.quit:
+			c.logger.Info("Bot stopped")
+			return
+		case m := <-c.events:
+			c.safely("handle", func() { bot.Handle(c, m) })
+		}
+	}
+}
+
+type botSay struct {
+	user   *User
+	kind   MessageKind
+	target string
+	text   string
+}
+
+func (r botSay) handle(h *Hub) {
+	user := r.user
+	if !h.present(user) {
+		return
+	}
+	for _, text := range strings.Split(r.text, "\n") {
+		text = sanitizeText(strings.TrimRight(text, "\r"))
+		if text == "" {
+			continue
+		}
+		if r.kind == PrivateMessage {
+			if err := h.sendPrivate(user, r.target, text); err != nil {
+				user.logger.Warn("Bot message not sent", "to", r.target, "err", err)
+			}
+			continue
+		}
+		for _, room := range r.rooms(user) {
+			room.handleMessage(Message{Kind: r.kind, From: user.Name, Text: text})
+		}
+	}
+}
+
+func (r botSay) rooms(user *User) []*ChatRoom {
+	if r.target == "" {
+		rooms := make([]*ChatRoom, 0, len(user.rooms))
+		for _, name := range roomNames(user.rooms) {
+			rooms = append(rooms, user.rooms[name])
+		}
+		return rooms
+	}
+	name, _ := normalizeRoomName(r.target)
+	room, ok := user.rooms[name]
+	if !ok {
+		user.logger.Warn("Bot is not in room", "room", r.target)
+		return nil
+	}
+	return []*ChatRoom{room}
+}
@@ -0,0 +1,64 @@
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type testBot struct {
+	name  string
+	seen  chan Message
+	panic bool
+}
+
+func (b *testBot) Name() string    { return b.name }
+func (b *testBot) Rooms() []string { return nil }
+
+func (b *testBot) Handle(c *BotClient, m Message) {
+	if m.Kind != ChatMessage {
+		return
+	}
+	b.seen <- m
+	if b.panic {
+		panic("boom")
+	}
+}
+
+// TestBotPanic tests that a panicking bot keeps receiving messages
+func TestBotPanic(t *testing.T) {
+	bans, _ := LoadBanList("")
+	hub := NewHub(DefaultConfig(), nil, bans, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hub.Run(ctx)
+
+	panicky := &testBot{name: "panicky", seen: make(chan Message, 2), panic: true}
+	if err := hub.AddBot(ctx, panicky); err != nil {
+		t.Fatalf("Expected bot to join, got %v", err)
+	}
+	talker := &testBot{name: "talker"}
+	if err := hub.AddBot(ctx, talker); err != nil {
+		t.Fatalf("Expected bot to join, got %v", err)
+	}
+	if err := hub.AddBot(ctx, &testBot{name: "talker"}); err == nil {
+		t.Errorf("Expected a second bot named talker to be refused")
+	}
+
+	rooms, _ := hub.Rooms()
+	if len(rooms) != 1 || len(rooms[0].Users) != 2 {
+		t.Errorf("Expected both bots in the default room, got %+v", rooms)
+	}
+
+	hub.do(botSay{user: hub.users["talker"], kind: ChatMessage, text: "one\ntwo"})
+	for _, want := range []string{"one", "two"} {
+		select {
+		case m := <-panicky.seen:
+			if m.Text != want || m.From != "talker" {
+				t.Errorf("Expected %s from talker, got %q from %s", want, m.Text, m.From)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %s to reach the bot", want)
+		}
+	}
+}
@@ -1,61 +1,111 @@
 package chat
 
 import (
-	"bufio"
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"time"
-	"unicode"
+
+	"github.com/insomnes/protohackers/pkg/lineio"
+	"github.com/insomnes/protohackers/pkg/logging"
 )
 
 type Butler struct {
-	chatRoom    *ChatRoom
+	cfg         Config
+	hub         *Hub
+	bans        *BanList
+	accounts    *AccountStore
 	namedGuests chan *Guest
-	connections chan net.Conn
+	connections chan incoming
+}
+
+type incoming struct {
+	conn  net.Conn
+	proto protocol
 }
 
-func NewButler(chatRoom *ChatRoom) Butler {
+// NewButler creates the butler. accounts may be nil.
+func NewButler(hub *Hub, bans *BanList, accounts *AccountStore, cfg Config) Butler {
 	return Butler{
-		chatRoom:    chatRoom,
+		cfg:         cfg,
+		hub:         hub,
+		bans:        bans,
+		accounts:    accounts,
 		namedGuests: make(chan *Guest, EventChannelSize),
-		connections: make(chan net.Conn, EventChannelSize),
+		connections: make(chan incoming, EventChannelSize),
 	}
 }
 
 func (b *Butler) Run(ctx context.Context) {
-	log.Println("Butler started")
+	slog.Info("Butler started")
 	butlerCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Butler stopped")
+			slog.Info("Butler stopped")
 			return
-		case conn := <-b.connections:
-			guest := NewGuest(conn)
+		case in := <-b.connections:
+			reader := lineio.NewReader(in.conn, b.cfg.MaxMessageSize, b.cfg.OversizePolicy)
+			guest := NewGuest(in.conn, reader, in.proto, in.proto.newCodec(b.hub.defaultRoom))
+			guest.names = b.cfg.Names
+			guest.accounts = b.accounts
+			guest.loginPolicy = b.cfg.LoginPolicy
+			guest.nameTaken = b.hub.NameTaken
+			if ban, banned := b.bans.Check(remoteIP(guest.Address()), time.Now()); banned {
+				chatMetrics.Refused("banned")
+				go guest.Reject(banMessage(ban))
+				continue
+			}
 			go guest.Greet(butlerCtx, b.namedGuests)
 		case guest := <-b.namedGuests:
-			b.chatRoom.AddGuest(guest)
+			if ban, banned := b.bans.Check(guest.Name, time.Now()); banned {
+				chatMetrics.Refused("banned")
+				go guest.Reject(banMessage(ban))
+				continue
+			}
+			b.hub.AddGuest(guest)
 		}
 	}
 }
 
-func (b *Butler) AddConnection(conn net.Conn) {
-	b.connections <- conn
+func (b *Butler) AddConnection(conn net.Conn, proto protocol) {
+	b.connections <- incoming{conn: conn, proto: proto}
 }
 
 type Guest struct {
+	ID   string
 	Name string
 	Conn net.Conn
+
+	proto       protocol
+	codec       codec
+	names       NamePolicy
+	accounts    *AccountStore
+	loginPolicy LoginPolicy
+	// account is the registered name the guest logged in to.
+	account string
+	// nameTaken asks the Hub whether a name is in use, for protocols that
+	// let guests pick another one.
+	nameTaken func(name string) bool
+
+	// reader is handed over to the User so no buffered input is lost.
+	reader *lineio.Reader
+	logger *slog.Logger
 }
 
-func NewGuest(conn net.Conn) *Guest {
+func NewGuest(conn net.Conn, reader *lineio.Reader, proto protocol, codec codec) *Guest {
+	id := logging.NewConnID()
 	return &Guest{
-		Name: "",
-		Conn: conn,
+		ID:     id,
+		Name:   "",
+		Conn:   conn,
+		proto:  proto,
+		codec:  codec,
+		reader: reader,
+		logger: logging.ForConn(slog.Default(), id, conn.RemoteAddr().String()),
 	}
 }
 
@@ -64,53 +114,37 @@ func (g *Guest) Greet(ctx context.Context, guests chan<- *Guest) {
 	go g.greet(done)
 	select {
 	case <-ctx.Done():
-		log.Printf("External stop for %s\n", g.Conn.RemoteAddr())
+		g.logger.Info("External stop for guest")
 		g.Close()
 		return
 	case <-done:
-		log.Printf("Accepted %s as %s\n", g.Conn.RemoteAddr(), g.Name)
+		g.logger.Info("Accepted guest", "name", g.Name)
 		guests <- g
 	}
 }
 
 func (g *Guest) greet(done chan<- struct{}) {
-	err := g.send("Welcome! What is your name?")
-	if err != nil {
-		log.Printf("Error writing to %s: %v\n", g.Conn.RemoteAddr(), err)
-		g.Close()
-		return
-	}
-
-	g.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-	reader := bufio.NewReader(g.Conn)
-	name, err := reader.ReadString('\n')
-	if err != nil {
-		if err.Error() == "EOF" {
-			log.Printf("%s closed connection\n", g.Conn.RemoteAddr())
-		} else {
-			log.Printf("Guest cannot read from %s: %v\n", g.Conn.RemoteAddr(), err)
-		}
-		g.Close()
-
+	if !g.proto.greet(g) {
 		return
 	}
+	g.logger = g.logger.With("name", g.Name)
+	close(done)
+}
 
-	name = name[:len(name)-1]
-	err = validateName(name)
-	if err != nil {
-		g.Reject(fmt.Sprintf("Invalid name %s: %v", name, err))
-		return
+func banMessage(ban Ban) string {
+	if ban.Until.IsZero() {
+		return "You are banned from this server"
 	}
+	return fmt.Sprintf("You are banned from this server until %s", ban.Until.Format(time.DateTime))
+}
 
-	g.Name = name
-	g.Conn.SetReadDeadline(time.Time{})
-
-	close(done)
+func (g *Guest) Address() string {
+	return g.Conn.RemoteAddr().String()
 }
 
 func (g *Guest) Reject(reason string) {
-	log.Printf("Rejecting %s: %s\n", g.Conn.RemoteAddr(), reason)
-	g.send(reason)
+	g.logger.Info("Rejecting guest", "reason", reason)
+	g.send(g.codec.reject(reason))
 	g.Close()
 }
 
@@ -119,27 +153,13 @@ func (g *Guest) Close() {
 }
 
 func (g *Guest) send(text string) error {
+	g.Conn.SetWriteDeadline(time.Now().Add(userWriteTimeout))
 	_, err := g.Conn.Write([]byte(text + "\n"))
 	if err != nil {
-		log.Printf("Error writing to %s: %v\n", g.Conn.RemoteAddr(), err)
+		g.logger.Warn("Error writing to guest", "err", err)
 		g.Conn.Close()
 		return err
 	}
 
 	return nil
 }
-
-func validateName(name string) error {
-	if name == "" {
-		return fmt.Errorf("name cannot be empty")
-	}
-	if len(name) > 20 {
-		return fmt.Errorf("name cannot be longer than 20 characters")
-	}
-	for _, c := range name {
-		if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
-			return fmt.Errorf("name can only contain letters and digits")
-		}
-	}
-	return nil
-}
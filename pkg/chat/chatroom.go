@@ -1,103 +1,190 @@
 package chat
 
 import (
-	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"sort"
 	"strings"
+	"time"
+	"unicode"
 )
 
-type ChatRoom struct {
-	users map[string]*User
+const (
+	DefaultRoom = "lobby"
+	maxRoomName = 32
+)
 
-	join     chan *Guest
-	messages chan Message
-	userFail chan UserError
+// ChatRoom is a named room with its own membership. It is owned by the
+// Hub event loop and must not be used from other goroutines.
+type ChatRoom struct {
+	Name string
+
+	users      map[string]*User
+	history    *history
+	transcript *Transcript
+	// replay is how many history messages new members get.
+	replay int
+	// relay, when set, is called with everything recorded, for
+	// federation.
+	relay func(Message)
 }
 
-func NewChatRoom() ChatRoom {
-	return ChatRoom{
-		users:    make(map[string]*User),
-		join:     make(chan *Guest, EventChannelSize),
-		messages: make(chan Message, EventChannelSize),
-		userFail: make(chan UserError, EventChannelSize),
+// NewChatRoom creates a room around its history, which outlives the room
+// itself. transcript may be nil.
+func NewChatRoom(name string, hist *history, transcript *Transcript, replay int) *ChatRoom {
+	return &ChatRoom{
+		Name:       name,
+		users:      make(map[string]*User),
+		history:    hist,
+		transcript: transcript,
+		replay:     replay,
 	}
 }
 
-func (cr *ChatRoom) Run(ctx context.Context) {
-	log.Println("ChatRoom started")
-	chatRoomCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("ChatRoom stopped")
-			return
-		case guest := <-cr.join:
-			cr.handleGuest(chatRoomCtx, guest)
-		case message := <-cr.messages:
-			cr.handleMessage(message)
-		case err := <-cr.userFail:
-			cr.handleUserError(err)
-		}
-	}
+func (cr *ChatRoom) Len() int {
+	return len(cr.users)
 }
 
-func (cr *ChatRoom) AddGuest(guest *Guest) {
-	cr.join <- guest
+func (cr *ChatRoom) Has(user *User) bool {
+	member, ok := cr.users[user.Name]
+	return ok && member == user
 }
 
-func (cr *ChatRoom) handleGuest(ctx context.Context, guest *Guest) {
-	log.Printf("Guest %s joined, checking name [%s]", guest.Conn.RemoteAddr(), guest.Name)
+// add tells the user it joined and who is there, replays history and then
+// announces the user to the others.
+func (cr *ChatRoom) add(user *User) {
+	joined := Message{
+		Kind:    JoinMessage,
+		Subject: user.Name,
+		Text:    fmt.Sprintf("%s joined", user.Name),
+		Origin:  user.origin(),
+	}
+	cr.send(user, joined)
+	cr.send(user, cr.names())
+	if cr.replay > 0 {
+		cr.sendHistory(user, cr.replay)
+	}
+	cr.handleMessage(joined)
+	cr.users[user.Name] = user
+	user.rooms[cr.Name] = cr
+}
 
-	if _, present := cr.users[guest.Name]; present {
-		guest.Reject("Name already taken. Sorry.")
+// remove takes the user out of the room and tells everyone with notice,
+// e.g. "alice left".
+func (cr *ChatRoom) remove(user *User, notice string) {
+	if !cr.Has(user) {
 		return
 	}
-	user := NewUser(guest.Conn, guest.Name)
-
-	go user.Run(ctx, cr.messages, cr.userFail)
+	parted := Message{Kind: PartMessage, Subject: user.Name, Text: notice, Origin: user.origin()}
+	delete(cr.users, user.Name)
+	delete(user.rooms, cr.Name)
+	cr.send(user, parted)
+	cr.handleMessage(parted)
+}
 
-	cr.notifyAboutNewUser(&user)
-	cr.users[user.Name] = &user
+func (cr *ChatRoom) names() Message {
+	names := make([]string, 0, len(cr.users))
+	for name := range cr.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return Message{Kind: NamesMessage, Names: names}
 }
 
-func (cr *ChatRoom) notifyAboutNewUser(user *User) {
-	cr.handleMessage(Message{Text: fmt.Sprintf("%s joined", user.Name)})
+// who is names with the presence of members, for /who. The list sent on
+// joining stays names only, since clients parse it.
+func (cr *ChatRoom) who() Message {
+	now := time.Now()
+	m := cr.names()
+	m.Status = make(map[string]string)
+	for _, name := range m.Names {
+		if presence := cr.users[name].presence(now); presence != "" {
+			m.Status[name] = presence
+		}
+	}
+	return m
+}
 
-	sb := strings.Builder{}
-	sb.WriteString("* Users in chatroom: ")
-	for name := range cr.users {
-		sb.WriteString(name)
-		sb.WriteString(" ")
+// sendHistory replays up to n recent messages followed by a marker line.
+// It reports false and sends nothing when there is no history.
+func (cr *ChatRoom) sendHistory(user *User, n int) bool {
+	messages := cr.history.last(n, time.Now())
+	if len(messages) == 0 {
+		return false
 	}
-	user.Send(sb.String())
+	for _, message := range messages {
+		message.History = true
+		cr.send(user, message)
+	}
+	cr.send(user, notice("End of history"))
+	return true
 }
 
 func (cr *ChatRoom) handleMessage(message Message) {
-	log.Println("Message:", message.String())
+	message.Room = cr.Name
+	if message.Time.IsZero() {
+		message.Time = time.Now()
+	}
+	if sender, ok := cr.users[message.From]; ok {
+		if sender.isMuted(message.Time) {
+			cr.send(sender, notice("You are muted, message not sent"))
+			return
+		}
+		sender.sent++
+		sender.lastActive = message.Time
+	}
+	cr.record(message)
+	if message.From != "" {
+		chatMetrics.Read(len(message.Text) + 1)
+	}
 	for _, user := range cr.users {
 		if user.Name == message.From {
 			continue
 		}
-		user.Send(message.String())
+		cr.send(user, message)
 	}
 }
 
-func (cr *ChatRoom) handleUserError(err UserError) {
-	delete(cr.users, err.Name)
-	cr.handleMessage(Message{Text: fmt.Sprintf("%s left", err.Name)})
+func (cr *ChatRoom) record(message Message) {
+	message.Room = cr.Name
+	cr.history.add(message)
+	if err := cr.transcript.Append(message); err != nil {
+		slog.Error("Failed to write transcript", "room", cr.Name, "err", err)
+	}
+	slog.Info("Message", "room", cr.Name, "from", message.From, "text", message.Text)
+	if cr.relay != nil {
+		cr.relay(message)
+	}
+}
+
+func (cr *ChatRoom) send(user *User, message Message) {
+	message.Room = cr.Name
+	user.Deliver(message)
 }
 
-type Message struct {
-	From string
-	Text string
+// roomNames returns the names of rooms sorted for stable output.
+func roomNames(rooms map[string]*ChatRoom) []string {
+	names := make([]string, 0, len(rooms))
+	for name := range rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
-func (m Message) String() string {
-	if m.From == "" {
-		return fmt.Sprintf("* %s", m.Text)
+// normalizeRoomName strips an optional leading "#" and lowercases the name.
+func normalizeRoomName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimPrefix(name, "#"))
+	if name == "" {
+		return "", fmt.Errorf("room name cannot be empty")
+	}
+	if len(name) > maxRoomName {
+		return "", fmt.Errorf("room name cannot be longer than %d characters", maxRoomName)
+	}
+	for _, c := range name {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '-' && c != '_' {
+			return "", fmt.Errorf("room name can only contain letters, digits, - and _")
+		}
 	}
-	return fmt.Sprintf("[%s] %s", m.From, m.Text)
+	return name, nil
 }
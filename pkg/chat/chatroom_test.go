@@ -0,0 +1,20 @@
+package chat
+
+import (
+	"testing"
+)
+
+// TestNamesWithoutStatus tests that presence shows in /who but not in the
+// list sent on joining
+func TestNamesWithoutStatus(t *testing.T) {
+	room := NewChatRoom("lobby", newHistory(0, 0), nil, 0)
+	room.users["alice"] = &User{Name: "alice", away: true}
+	room.users["clock"] = &User{Name: "clock", bot: true}
+
+	if got, want := room.names().String(), "* Users in chatroom: alice clock "; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got, want := room.who().String(), "* Users in chatroom: alice (away) clock (bot) "; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,453 @@
+package chat
+
+import (
+	"cmp"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type command struct {
+	usage string
+	help  string
+	run   func(h *Hub, user *User, args string)
+	// oper commands are only available to operators.
+	oper bool
+}
+
+var commands map[string]command
+
+// init breaks the initialization cycle between commands and cmdHelp.
+func init() {
+	commands = map[string]command{
+		"msg":      {"/msg <name> <text>", "send a private message", cmdMsg, false},
+		"who":      {"/who", "list users in the current room", cmdWho, false},
+		"me":       {"/me <action>", "describe an action", cmdMe, false},
+		"join":     {"/join <room>", "join a room and talk there", cmdJoin, false},
+		"part":     {"/part [room]", "leave a room, the current one by default", cmdPart, false},
+		"rooms":    {"/rooms", "list rooms", cmdRooms, false},
+		"history":  {"/history [n]", "show recent messages of the current room", cmdHistory, false},
+		"nick":     {"/nick <name>", "change your name", cmdNick, false},
+		"away":     {"/away [message]", "tell others you are away", cmdAway, false},
+		"back":     {"/back", "tell others you are back", cmdBack, false},
+		"register": {"/register <password>", "register your name so only you can take it", cmdRegister, false},
+		"oper":     {"/oper <password>", "become an operator", cmdOper, false},
+		"quit":     {"/quit [reason]", "leave the chat", cmdQuit, false},
+		"help":     {"/help", "show this help", cmdHelp, false},
+
+		"kick":   {"/kick <name> [reason]", "disconnect a user", cmdKick, true},
+		"ban":    {"/ban <name|ip> [duration]", "ban a name or address, e.g. /ban bob 1h", cmdBan, true},
+		"unban":  {"/unban <name|ip>", "lift a ban", cmdUnban, true},
+		"bans":   {"/bans", "list bans", cmdBans, true},
+		"mute":   {"/mute <name> [duration]", "stop a user from talking", cmdMute, true},
+		"unmute": {"/unmute <name>", "let a muted user talk again", cmdUnmute, true},
+	}
+}
+
+func parseCommand(text string) (name, args string) {
+	name, args, _ = strings.Cut(strings.TrimPrefix(text, "/"), " ")
+	return strings.ToLower(name), strings.TrimSpace(args)
+}
+
+func (h *Hub) handleCommand(user *User, text string) {
+	name, args := parseCommand(text)
+	cmd, ok := commands[name]
+	if !ok {
+		user.logger.Info("Unknown command", "command", name)
+		h.reply(user, "Error: unknown command /%s, try /help", name)
+		return
+	}
+	if cmd.oper && !user.oper {
+		user.logger.Warn("Operator command refused", "command", name)
+		h.reply(user, "Error: /%s is for operators only", name)
+		return
+	}
+	user.logger.Info("Command", "command", name)
+	user.lastActive = time.Now()
+	cmd.run(h, user, args)
+}
+
+func (h *Hub) usage(user *User, name string) {
+	h.reply(user, "Usage: %s", commands[name].usage)
+}
+
+// currentRoom returns the user's current room, telling the user when
+// there is none.
+func (h *Hub) currentRoom(user *User) (*ChatRoom, bool) {
+	if user.room == nil {
+		h.reply(user, "Error: you are not in any room, try /join <room>")
+		return nil, false
+	}
+	return user.room, true
+}
+
+func cmdMsg(h *Hub, user *User, args string) {
+	to, text, _ := strings.Cut(args, " ")
+	text = strings.TrimSpace(text)
+	if to == "" || text == "" {
+		h.usage(user, "msg")
+		return
+	}
+	switch err := h.sendPrivate(user, to, text); {
+	case errors.Is(err, errNoSuchUser):
+		h.reply(user, "Error: no such user %s", to)
+	case errors.Is(err, errMuted):
+		h.reply(user, "You are muted, message not sent")
+	case err == nil:
+		if target, _ := h.lookup(to); target.away {
+			h.reply(user, "%s is away: %s", target.Name, cmp.Or(target.awayText, "no message"))
+		}
+	}
+}
+
+func cmdNick(h *Hub, user *User, args string) {
+	if args == "" {
+		h.usage(user, "nick")
+		return
+	}
+	if args == user.Name {
+		h.reply(user, "You are already known as %s", args)
+		return
+	}
+	if err := h.rename(user, args); err != nil {
+		h.reply(user, "Error: cannot change name to %s: %v", args, err)
+	}
+}
+
+func cmdAway(h *Hub, user *User, args string) {
+	h.setAway(user, true, args)
+}
+
+func cmdBack(h *Hub, user *User, _ string) {
+	if !h.setAway(user, false, "") {
+		h.reply(user, "You are not away")
+	}
+}
+
+func cmdWho(h *Hub, user *User, _ string) {
+	room, ok := h.currentRoom(user)
+	if !ok {
+		return
+	}
+	room.send(user, room.who())
+}
+
+func cmdMe(h *Hub, user *User, args string) {
+	if args == "" {
+		h.usage(user, "me")
+		return
+	}
+	room, ok := h.currentRoom(user)
+	if !ok {
+		return
+	}
+	room.handleMessage(Message{Kind: ActionMessage, From: user.Name, Text: args})
+}
+
+func cmdJoin(h *Hub, user *User, args string) {
+	if args == "" {
+		h.usage(user, "join")
+		return
+	}
+	name, err := normalizeRoomName(args)
+	if err != nil {
+		h.reply(user, "Error: %v", err)
+		return
+	}
+	h.joinRoom(user, name)
+	h.reply(user, "Now talking in #%s", name)
+}
+
+func cmdPart(h *Hub, user *User, args string) {
+	room := user.room
+	if args != "" {
+		name, err := normalizeRoomName(args)
+		if err != nil {
+			h.reply(user, "Error: %v", err)
+			return
+		}
+		room = user.rooms[name]
+	}
+	if room == nil {
+		h.reply(user, "Error: you are not in that room")
+		return
+	}
+	h.partRoom(user, room, leftNotice(user.Name, ""))
+	if user.room == nil {
+		h.reply(user, "Left #%s, you are not in any room now", room.Name)
+		return
+	}
+	h.reply(user, "Left #%s, now talking in #%s", room.Name, user.room.Name)
+}
+
+func cmdRooms(h *Hub, user *User, _ string) {
+	sb := strings.Builder{}
+	sb.WriteString("Rooms:")
+	for _, name := range roomNames(h.rooms) {
+		sb.WriteString(fmt.Sprintf(" #%s (%d)", name, h.rooms[name].Len()))
+	}
+	h.reply(user, "%s", sb.String())
+}
+
+func cmdHistory(h *Hub, user *User, args string) {
+	room, ok := h.currentRoom(user)
+	if !ok {
+		return
+	}
+	n := room.history.size()
+	if args != "" {
+		parsed, err := strconv.Atoi(args)
+		if err != nil || parsed <= 0 {
+			h.usage(user, "history")
+			return
+		}
+		n = min(parsed, n)
+	}
+	if !room.sendHistory(user, n) {
+		h.reply(user, "No history in #%s", room.Name)
+	}
+}
+
+func cmdQuit(h *Hub, user *User, args string) {
+	h.reply(user, "Bye!")
+	h.removeUser(user, leftNotice(user.Name, args))
+	user.Quit()
+}
+
+func cmdHelp(h *Hub, user *User, _ string) {
+	names := make([]string, 0, len(commands))
+	for name, cmd := range commands {
+		if cmd.oper && !user.oper {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h.reply(user, "Commands:")
+	for _, name := range names {
+		cmd := commands[name]
+		h.reply(user, "%s - %s", cmd.usage, cmd.help)
+	}
+	h.reply(user, "Lines starting with // are sent as messages starting with /")
+}
+
+func cmdOper(h *Hub, user *User, args string) {
+	switch {
+	case user.oper:
+		h.reply(user, "You are already an operator")
+	case h.cfg.OperPassword == "":
+		h.reply(user, "Error: /oper is disabled")
+	case subtle.ConstantTimeCompare([]byte(args), []byte(h.cfg.OperPassword)) != 1:
+		user.logger.Warn("Failed /oper attempt")
+		h.reply(user, "Error: wrong password")
+	default:
+		user.logger.Info("User is now an operator")
+		user.oper = true
+		h.reply(user, "You are now an operator, see /help")
+	}
+}
+
+func cmdRegister(h *Hub, user *User, args string) {
+	if args == "" {
+		h.usage(user, "register")
+		return
+	}
+	if h.accounts == nil {
+		h.reply(user, "Error: %v", errAccountsDisabled)
+		return
+	}
+	if h.accounts.Registered(user.Name) {
+		h.reply(user, "Error: %s is already registered", user.Name)
+		return
+	}
+	// Hashing the password takes a while, so it is done off the event
+	// loop.
+	name := user.Name
+	go func() {
+		err := h.accounts.Register(name, args)
+		h.do(accountRegistered{user: user, name: name, err: err})
+	}()
+}
+
+// targetUser looks up the user named in args, telling the operator when
+// there is no such user.
+func (h *Hub) targetUser(user *User, name string) (*User, bool) {
+	target, ok := h.lookup(name)
+	if !ok {
+		h.reply(user, "Error: no such user %s", name)
+		return nil, false
+	}
+	if target.remote != nil {
+		h.reply(user, "Error: %s is on %s, ask an operator there", name, target.remote.origin)
+		return nil, false
+	}
+	return target, true
+}
+
+// parseTargetDuration splits "<target> [duration]". A missing duration is
+// zero.
+func parseTargetDuration(args string) (string, time.Duration, error) {
+	target, rest, _ := strings.Cut(args, " ")
+	rest = strings.TrimSpace(rest)
+	if target == "" {
+		return "", 0, fmt.Errorf("missing target")
+	}
+	if rest == "" {
+		return target, 0, nil
+	}
+	d, err := time.ParseDuration(rest)
+	if err != nil || d <= 0 {
+		return "", 0, fmt.Errorf("invalid duration %q", rest)
+	}
+	return target, d, nil
+}
+
+func cmdKick(h *Hub, user *User, args string) {
+	name, reason, _ := strings.Cut(args, " ")
+	reason = strings.TrimSpace(reason)
+	if name == "" {
+		h.usage(user, "kick")
+		return
+	}
+	target, ok := h.targetUser(user, name)
+	if !ok {
+		return
+	}
+	h.kickBy(target, user.Name, reason)
+}
+
+// kickBy kicks the user on behalf of by, usually an operator.
+func (h *Hub) kickBy(target *User, by, reason string) {
+	notice := fmt.Sprintf("%s was kicked by %s", target.Name, by)
+	farewell := fmt.Sprintf("You were kicked by %s", by)
+	if reason != "" {
+		notice = fmt.Sprintf("%s (%s)", notice, reason)
+		farewell = fmt.Sprintf("%s: %s", farewell, reason)
+	}
+	h.kick(target, notice, farewell)
+}
+
+func cmdBan(h *Hub, user *User, args string) {
+	target, d, err := parseTargetDuration(args)
+	if err != nil {
+		h.usage(user, "ban")
+		return
+	}
+	now := time.Now()
+	ban := Ban{Target: target, By: user.Name, Since: now}
+	if d > 0 {
+		ban.Until = now.Add(d)
+	}
+	if err := h.bans.Add(ban); err != nil {
+		user.logger.Error("Failed to save ban list", "err", err)
+		h.reply(user, "Error: ban is active but could not be saved: %v", err)
+	}
+	user.logger.Info("Ban added", "ban", ban.String())
+	h.reply(user, "Banned %s", ban)
+
+	h.enforceBan(ban, user)
+}
+
+// enforceBan kicks the connected users the ban applies to, except the one
+// who issued it, and returns their names.
+func (h *Hub) enforceBan(ban Ban, except *User) []string {
+	kicked := []string{}
+	for _, other := range h.usersMatching(ban) {
+		if other == except || other.remote != nil {
+			continue
+		}
+		h.kick(other,
+			fmt.Sprintf("%s was banned by %s", other.Name, ban.By),
+			banMessage(ban),
+		)
+		kicked = append(kicked, other.Name)
+	}
+	sort.Strings(kicked)
+	return kicked
+}
+
+func (h *Hub) usersMatching(ban Ban) []*User {
+	if !ban.IsIP() {
+		if target, ok := h.lookup(ban.Target); ok {
+			return []*User{target}
+		}
+		return nil
+	}
+	var matching []*User
+	for _, other := range h.users {
+		if remoteIP(other.Address) == ban.Target {
+			matching = append(matching, other)
+		}
+	}
+	return matching
+}
+
+func cmdUnban(h *Hub, user *User, args string) {
+	if args == "" {
+		h.usage(user, "unban")
+		return
+	}
+	removed, err := h.bans.Remove(args)
+	switch {
+	case !removed:
+		h.reply(user, "Error: %s is not banned", args)
+	case err != nil:
+		user.logger.Error("Failed to save ban list", "err", err)
+		h.reply(user, "Error: ban is lifted but could not be saved: %v", err)
+	default:
+		user.logger.Info("Ban removed", "target", args)
+		h.reply(user, "Unbanned %s", args)
+	}
+}
+
+func cmdBans(h *Hub, user *User, _ string) {
+	bans := h.bans.List(time.Now())
+	if len(bans) == 0 {
+		h.reply(user, "No bans")
+		return
+	}
+	for _, ban := range bans {
+		h.reply(user, "Ban: %s by %s", ban, ban.By)
+	}
+}
+
+func cmdMute(h *Hub, user *User, args string) {
+	name, d, err := parseTargetDuration(args)
+	if err != nil {
+		h.usage(user, "mute")
+		return
+	}
+	target, ok := h.targetUser(user, name)
+	if !ok {
+		return
+	}
+	target.muted = true
+	target.mutedUntil = time.Time{}
+	notice := fmt.Sprintf("You were muted by %s", user.Name)
+	if d > 0 {
+		target.mutedUntil = time.Now().Add(d)
+		notice = fmt.Sprintf("%s for %s", notice, d)
+	}
+	target.logger.Info("User muted", "by", user.Name, "duration", d)
+	h.reply(target, "%s", notice)
+	h.reply(user, "Muted %s", target.Name)
+}
+
+func cmdUnmute(h *Hub, user *User, args string) {
+	if args == "" {
+		h.usage(user, "unmute")
+		return
+	}
+	target, ok := h.targetUser(user, args)
+	if !ok {
+		return
+	}
+	target.muted = false
+	h.reply(target, "You can talk again")
+	h.reply(user, "Unmuted %s", target.Name)
+}
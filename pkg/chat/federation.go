@@ -0,0 +1,385 @@
+package chat
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/insomnes/protohackers/pkg/metrics"
+)
+
+// recentFrames is how many frame IDs are remembered to drop duplicates
+// arriving over another path.
+const recentFrames = 4096
+
+var federationFrames = metrics.Default.Counter(
+	"protohackers_chat_federation_frames_total",
+	"Frames exchanged with federated chat servers.",
+	"direction",
+)
+
+// FederationConfig links chat servers into one logical chat. Links
+// should form a tree: duplicates arriving over a cycle are dropped, but
+// presence is only kept per link.
+type FederationConfig struct {
+	// Name identifies this server to its peers and suffixes remote names
+	// that collide with local ones. Federation is off while it is empty.
+	Name string `json:"name"`
+	// Listen is the address peers dial, empty to only dial out.
+	Listen string `json:"listen"`
+	// Peers are the addresses dialed and redialed with backoff.
+	Peers []string `json:"peers"`
+	// Secret must be the same on both ends of a link. Peers prove they
+	// know it without sending it, but frames are neither encrypted nor
+	// signed, so links belong on a trusted network or a tunnel.
+	Secret string `json:"secret"`
+}
+
+func (fc FederationConfig) validate() error {
+	if err := validRemoteName(fc.Name); err != nil {
+		return fmt.Errorf("invalid federation name %q: %w", fc.Name, err)
+	}
+	if fc.Secret == "" {
+		return fmt.Errorf("federation needs a secret")
+	}
+	return nil
+}
+
+type remoteUser struct {
+	origin string
+	// name is the user's name on its own server, which differs from
+	// User.Name when that collided here.
+	name string
+	// via is the link the user was learned from.
+	via *link
+}
+
+type remoteKey struct {
+	origin string
+	name   string
+}
+
+// federation is the Hub's view of its links. It belongs to the Hub event
+// loop.
+type federation struct {
+	cfg    FederationConfig
+	links  map[string]*link
+	remote map[remoteKey]*User
+	seen   *recentIDs
+	seq    uint64
+}
+
+func newFederation(cfg FederationConfig) *federation {
+	return &federation{
+		cfg:    cfg,
+		links:  make(map[string]*link),
+		remote: make(map[remoteKey]*User),
+		seen:   newRecentIDs(recentFrames),
+	}
+}
+
+// newFrame returns a frame with a fresh ID, from this server unless
+// origin says otherwise.
+func (h *Hub) newFrame(typ frameType, origin string) frame {
+	h.fed.seq++
+	return frame{
+		Type:   typ,
+		ID:     fmt.Sprintf("%s:%d", h.fed.cfg.Name, h.fed.seq),
+		Origin: cmp.Or(origin, h.fed.cfg.Name),
+		Time:   time.Now(),
+	}
+}
+
+func (h *Hub) broadcastFrame(f frame, except *link) {
+	for _, l := range h.fed.links {
+		if l != except {
+			l.send(f)
+		}
+	}
+}
+
+// relay passes on what local users do in rooms. It is the rooms' relay
+// hook.
+func (h *Hub) relay(m Message) {
+	if m.Origin != "" || m.History {
+		return
+	}
+	var f frame
+	switch {
+	case m.Kind == JoinMessage:
+		f = h.newFrame(frameJoin, "")
+		f.User = m.Subject
+	case m.Kind == PartMessage:
+		f = h.newFrame(framePart, "")
+		f.User, f.Text = m.Subject, m.Text
+	case (m.Kind == ChatMessage || m.Kind == ActionMessage) && m.From != "":
+		f = h.newFrame(frameMessage, "")
+		f.User, f.Kind, f.Text = m.From, m.Kind, m.Text
+	default:
+		return
+	}
+	f.Room = m.Room
+	h.broadcastFrame(f, nil)
+}
+
+func (h *Hub) relayNick(old, name string) {
+	if h.fed == nil {
+		return
+	}
+	f := h.newFrame(frameNick, "")
+	f.User, f.To = old, name
+	h.broadcastFrame(f, nil)
+}
+
+func (h *Hub) relayPrivate(target *User, m Message) {
+	f := h.newFrame(framePrivate, "")
+	f.User, f.Target, f.To, f.Text = m.From, target.remote.origin, target.remote.name, m.Text
+	target.remote.via.send(f)
+}
+
+// remoteName is the local name for a remote user: its own unless that is
+// taken here, then suffixed with its server. Local names cannot contain
+// "_", so suffixed names never collide with them.
+func (h *Hub) remoteName(origin, name string) string {
+	if _, taken := h.lookup(name); !taken {
+		return name
+	}
+	return name + "_" + origin
+}
+
+func (h *Hub) remoteUser(origin, name string, l *link) *User {
+	key := remoteKey{origin, name}
+	if user, ok := h.fed.remote[key]; ok {
+		return user
+	}
+	id := fmt.Sprintf("%s@%s", name, origin)
+	now := time.Now()
+	user := &User{
+		ID:      id,
+		Address: origin,
+		Name:    h.remoteName(origin, name),
+		codec:   remoteCodec{hub: h},
+		queue:   newSendQueue(h.cfg.SendQueueSize, SlowDropOldest),
+		quit:    make(chan struct{}),
+		slow:    make(chan struct{}),
+		rooms:   make(map[string]*ChatRoom),
+		logger:  l.logger.With("name", id),
+		remote:  &remoteUser{origin: origin, name: name, via: l},
+
+		connectedAt: now,
+		lastSeen:    now,
+		lastActive:  now,
+	}
+	h.fed.remote[key] = user
+	h.users[nameKey(user.Name)] = user
+	return user
+}
+
+func (h *Hub) dropRemote(user *User, notice string) {
+	h.removeUser(user, notice)
+	delete(h.fed.remote, remoteKey{user.remote.origin, user.remote.name})
+}
+
+// remoteCodec passes private messages for remote users on to their
+// server. Everything else reaches it through the room relay.
+type remoteCodec struct {
+	hub *Hub
+}
+
+func (remoteCodec) handle(*Hub, *User, string) {}
+
+func (c remoteCodec) format(user *User, m Message) []string {
+	if m.Kind == PrivateMessage && m.To == user.Name && m.From != user.Name {
+		c.hub.relayPrivate(user, m)
+	}
+	return nil
+}
+
+func (remoteCodec) reject(reason string) string {
+	return reason
+}
+
+type linkUp struct {
+	link  *link
+	reply chan error
+}
+
+func (r linkUp) handle(h *Hub) {
+	l := r.link
+	if l.peer == h.fed.cfg.Name {
+		r.reply <- fmt.Errorf("peer uses our own name %s", l.peer)
+		return
+	}
+	if _, ok := h.fed.links[l.peer]; ok {
+		r.reply <- fmt.Errorf("already linked to %s", l.peer)
+		return
+	}
+	h.fed.links[l.peer] = l
+	l.logger.Info("Federation link up")
+
+	// Tell the peer about everyone it cannot know through itself.
+	names := make([]string, 0, len(h.users))
+	for name := range h.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		user := h.users[name]
+		origin, original := "", user.Name
+		if user.remote != nil {
+			if user.remote.via == l || user.remote.origin == l.peer {
+				continue
+			}
+			origin, original = user.remote.origin, user.remote.name
+		}
+		for _, room := range roomNames(user.rooms) {
+			f := h.newFrame(frameJoin, origin)
+			f.User, f.Room = original, room
+			l.send(f)
+		}
+	}
+	r.reply <- nil
+}
+
+type linkDown struct {
+	link *link
+}
+
+// handle drops the users learned over the link and tells the other links
+// they are gone.
+func (r linkDown) handle(h *Hub) {
+	l := r.link
+	if h.fed.links[l.peer] != l {
+		return
+	}
+	delete(h.fed.links, l.peer)
+	l.logger.Warn("Federation link down")
+
+	var lost []*User
+	for _, user := range h.fed.remote {
+		if user.remote.via == l {
+			lost = append(lost, user)
+		}
+	}
+	sort.Slice(lost, func(i, j int) bool { return lost[i].Name < lost[j].Name })
+	for _, user := range lost {
+		for _, room := range roomNames(user.rooms) {
+			f := h.newFrame(framePart, user.remote.origin)
+			f.User, f.Room = user.remote.name, room
+			f.Text = leftNotice(user.remote.name, "lost link to "+l.peer)
+			h.broadcastFrame(f, l)
+		}
+		h.dropRemote(user, leftNotice(user.Name, "lost link to "+l.peer))
+	}
+}
+
+type linkFrame struct {
+	link  *link
+	frame frame
+}
+
+func (r linkFrame) handle(h *Hub) {
+	f := r.frame
+	if f.Origin == h.fed.cfg.Name || !h.fed.seen.add(f.ID) {
+		return
+	}
+	if err := f.check(); err != nil {
+		r.link.logger.Warn("Dropping bad frame", "type", f.Type, "id", f.ID, "err", err)
+		return
+	}
+	if f.Type == framePrivate && f.Target == h.fed.cfg.Name {
+		h.deliverPrivate(f)
+		return
+	}
+	h.applyFrame(r.link, f)
+	h.broadcastFrame(f, r.link)
+}
+
+func (h *Hub) applyFrame(l *link, f frame) {
+	key := remoteKey{f.Origin, f.User}
+	switch f.Type {
+	case frameJoin:
+		h.joinRoom(h.remoteUser(f.Origin, f.User, l), f.Room)
+	case framePart:
+		user, ok := h.fed.remote[key]
+		if !ok {
+			return
+		}
+		room, ok := user.rooms[f.Room]
+		if !ok {
+			return
+		}
+		notice := f.Text
+		if rest, ok := strings.CutPrefix(notice, f.User); ok {
+			notice = user.Name + rest
+		}
+		h.partRoom(user, room, notice)
+		if len(user.rooms) == 0 {
+			h.dropRemote(user, notice)
+		}
+	case frameMessage:
+		user := h.remoteUser(f.Origin, f.User, l)
+		if _, ok := user.rooms[f.Room]; !ok {
+			// The join went missing; better late than never.
+			h.joinRoom(user, f.Room)
+		}
+		user.lastActive = f.Time
+		user.rooms[f.Room].handleMessage(Message{
+			Kind:   f.Kind,
+			From:   user.Name,
+			Text:   f.Text,
+			Time:   f.Time,
+			Origin: f.Origin,
+		})
+	case frameNick:
+		user, ok := h.fed.remote[key]
+		if !ok {
+			return
+		}
+		delete(h.fed.remote, key)
+		user.remote.name = f.To
+		h.fed.remote[remoteKey{f.Origin, f.To}] = user
+		h.renameUser(user, h.remoteName(f.Origin, f.To))
+	}
+}
+
+func (h *Hub) deliverPrivate(f frame) {
+	target, ok := h.lookup(f.To)
+	if !ok || target.remote != nil {
+		slog.Info("Private message for unknown user", "to", f.To, "origin", f.Origin)
+		return
+	}
+	from := f.User + "_" + f.Origin
+	if sender, ok := h.fed.remote[remoteKey{f.Origin, f.User}]; ok {
+		from = sender.Name
+	}
+	target.Deliver(Message{Kind: PrivateMessage, From: from, To: target.Name, Text: f.Text, Time: f.Time, Origin: f.Origin})
+}
+
+// recentIDs is a fixed-size set that forgets the oldest IDs first.
+type recentIDs struct {
+	ids  map[string]struct{}
+	ring []string
+	next int
+}
+
+func newRecentIDs(size int) *recentIDs {
+	return &recentIDs{ids: make(map[string]struct{}, size), ring: make([]string, size)}
+}
+
+// add reports false if id was already seen.
+func (r *recentIDs) add(id string) bool {
+	if _, ok := r.ids[id]; ok {
+		return false
+	}
+	if old := r.ring[r.next]; old != "" {
+		delete(r.ids, old)
+	}
+	r.ring[r.next] = id
+	r.ids[id] = struct{}{}
+	r.next = (r.next + 1) % len(r.ring)
+	return true
+}
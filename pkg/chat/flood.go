@@ -0,0 +1,132 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/insomnes/protohackers/pkg/config"
+	"github.com/insomnes/protohackers/pkg/limiter"
+)
+
+const (
+	DefaultFloodMaxViolations = 10
+	DefaultFloodMuteFor       = 30 * time.Second
+)
+
+// ErrFlooding is the reason a user is disconnected under FloodDisconnect.
+var ErrFlooding = errors.New("flooding")
+
+// FloodAction is what happens to a user who keeps flooding after the
+// warning.
+type FloodAction int
+
+const (
+	FloodMute FloodAction = iota
+	FloodDisconnect
+)
+
+func (a FloodAction) String() string {
+	return [...]string{"mute", "disconnect"}[a]
+}
+
+func ParseFloodAction(s string) (FloodAction, error) {
+	switch strings.ToLower(s) {
+	case "mute":
+		return FloodMute, nil
+	case "disconnect":
+		return FloodDisconnect, nil
+	}
+	return 0, fmt.Errorf("unknown flood action %q", s)
+}
+
+func (a FloodAction) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *FloodAction) UnmarshalText(b []byte) error {
+	parsed, err := ParseFloodAction(string(b))
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+type FloodConfig struct {
+	// Rate is how many lines per second a user may send, zero disables
+	// flood control.
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+	// MaxViolations is how many lines may be dropped before Action is
+	// taken. The count resets once the user calms down.
+	MaxViolations int         `json:"max_violations"`
+	Action        FloodAction `json:"action"`
+	// MuteFor is how long FloodMute drops the user's lines.
+	MuteFor config.Duration `json:"mute_for"`
+}
+
+type floodVerdict int
+
+const (
+	floodAllow floodVerdict = iota
+	// floodWarn drops the first line over the limit.
+	floodWarn
+	floodDrop
+	floodMuted
+	floodDisconnect
+)
+
+// floodGuard meters one user's lines. It is only used from the user's
+// read loop.
+type floodGuard struct {
+	cfg        FloodConfig
+	bucket     *limiter.TokenBucket
+	violations int
+	mutedUntil time.Time
+}
+
+// newFloodGuard returns nil when flood control is disabled; a nil guard
+// allows everything.
+func newFloodGuard(cfg FloodConfig, now time.Time) *floodGuard {
+	if cfg.Rate <= 0 {
+		return nil
+	}
+	if cfg.MuteFor.Duration <= 0 {
+		cfg.MuteFor.Duration = DefaultFloodMuteFor
+	}
+	return &floodGuard{
+		cfg:    cfg,
+		bucket: limiter.NewTokenBucket(cfg.Rate, cfg.Burst, now),
+	}
+}
+
+func (g *floodGuard) check(now time.Time) floodVerdict {
+	if g == nil {
+		return floodAllow
+	}
+	if now.Before(g.mutedUntil) {
+		return floodDrop
+	}
+	if g.violations > 0 && g.bucket.Full(now) {
+		g.violations = 0
+	}
+	if g.bucket.Allow(now) {
+		return floodAllow
+	}
+
+	g.violations++
+	if g.cfg.MaxViolations > 0 && g.violations >= g.cfg.MaxViolations {
+		g.violations = 0
+		if g.cfg.Action == FloodDisconnect {
+			return floodDisconnect
+		}
+		g.mutedUntil = now.Add(g.cfg.MuteFor.Duration)
+		return floodMuted
+	}
+	if g.violations == 1 {
+		return floodWarn
+	}
+	return floodDrop
+}
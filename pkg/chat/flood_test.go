@@ -0,0 +1,42 @@
+package chat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/insomnes/protohackers/pkg/config"
+)
+
+// TestFloodGuard tests the warn, drop and mute sequence and the reset after calming down
+func TestFloodGuard(t *testing.T) {
+	now := time.Now()
+	g := newFloodGuard(FloodConfig{
+		Rate:          1,
+		Burst:         2,
+		MaxViolations: 3,
+		Action:        FloodMute,
+		MuteFor:       config.Duration{Duration: time.Minute},
+	}, now)
+
+	want := []floodVerdict{floodAllow, floodAllow, floodWarn, floodDrop, floodMuted, floodDrop}
+	for i, w := range want {
+		if got := g.check(now); got != w {
+			t.Errorf("Expected verdict %d at line %d, got %d", w, i, got)
+		}
+	}
+
+	later := now.Add(2 * time.Minute)
+	if got := g.check(later); got != floodAllow {
+		t.Errorf("Expected lines to be allowed after the mute, got %d", got)
+	}
+}
+
+// TestFloodGuardDisabled tests that a zero rate allows everything
+func TestFloodGuardDisabled(t *testing.T) {
+	g := newFloodGuard(FloodConfig{}, time.Now())
+	for i := 0; i < 100; i++ {
+		if got := g.check(time.Now()); got != floodAllow {
+			t.Fatalf("Expected floodAllow, got %d", got)
+		}
+	}
+}
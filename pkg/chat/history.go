@@ -0,0 +1,55 @@
+package chat
+
+import "time"
+
+// history is a fixed-size ring of a room's most recent messages.
+type history struct {
+	entries []Message
+	start   int
+	n       int
+	maxAge  time.Duration
+}
+
+func newHistory(size int, maxAge time.Duration) *history {
+	if size < 0 {
+		size = 0
+	}
+	return &history{
+		entries: make([]Message, size),
+		maxAge:  maxAge,
+	}
+}
+
+func (h *history) add(m Message) {
+	if len(h.entries) == 0 {
+		return
+	}
+	if h.n < len(h.entries) {
+		h.entries[(h.start+h.n)%len(h.entries)] = m
+		h.n++
+		return
+	}
+	h.entries[h.start] = m
+	h.start = (h.start + 1) % len(h.entries)
+}
+
+// last returns up to n of the newest messages, oldest first, leaving out
+// those older than maxAge.
+func (h *history) last(n int, now time.Time) []Message {
+	if n > h.n {
+		n = h.n
+	}
+	out := make([]Message, 0, n)
+	for i := h.n - n; i < h.n; i++ {
+		m := h.entries[(h.start+i)%len(h.entries)]
+		if h.maxAge > 0 && now.Sub(m.Time) > h.maxAge {
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func (h *history) size() int {
+	return len(h.entries)
+}
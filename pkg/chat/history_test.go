@@ -0,0 +1,43 @@
+package chat
+
+import (
+	"testing"
+	"time"
+)
+
+// TestHistoryWraps tests that the ring keeps only the newest messages in order
+func TestHistoryWraps(t *testing.T) {
+	now := time.Now()
+	h := newHistory(3, 0)
+	for _, text := range []string{"a", "b", "c", "d", "e"} {
+		h.add(Message{Text: text, Time: now})
+	}
+
+	got := h.last(10, now)
+	want := []string{"c", "d", "e"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d messages, got %d", len(want), len(got))
+	}
+	for i, m := range got {
+		if m.Text != want[i] {
+			t.Errorf("Expected %s at %d, got %s", want[i], i, m.Text)
+		}
+	}
+
+	if got := h.last(1, now); len(got) != 1 || got[0].Text != "e" {
+		t.Errorf("Expected [e], got %v", got)
+	}
+}
+
+// TestHistoryMaxAge tests that messages older than maxAge are not returned
+func TestHistoryMaxAge(t *testing.T) {
+	now := time.Now()
+	h := newHistory(5, time.Minute)
+	h.add(Message{Text: "old", Time: now.Add(-2 * time.Minute)})
+	h.add(Message{Text: "new", Time: now})
+
+	got := h.last(5, now)
+	if len(got) != 1 || got[0].Text != "new" {
+		t.Errorf("Expected [new], got %v", got)
+	}
+}
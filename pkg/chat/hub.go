@@ -0,0 +1,362 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Hub is the room registry. Its event loop is the only goroutine touching
+// users and rooms, so none of them need locking.
+type Hub struct {
+	cfg         Config
+	defaultRoom string
+	// users are keyed by nameKey.
+	users map[string]*User
+	rooms map[string]*ChatRoom
+	// histories are kept by room name so they survive empty rooms.
+	histories  map[string]*history
+	transcript *Transcript
+	bans       *BanList
+	accounts   *AccountStore
+	// fed is nil unless the server is federated.
+	fed *federation
+
+	join     chan *Guest
+	lines    chan Line
+	userFail chan UserError
+	requests chan hubRequest
+	// done is closed when Run returns. Requests still queued then are never
+	// handled, so callers waiting for a reply watch it too, see ask.
+	done chan struct{}
+}
+
+// hubRequest is work done on the event loop for another goroutine.
+type hubRequest interface {
+	handle(h *Hub)
+}
+
+// NewHub creates the registry with the default room. transcript and
+// accounts may be nil.
+func NewHub(cfg Config, transcript *Transcript, bans *BanList, accounts *AccountStore) *Hub {
+	defaultRoom, err := normalizeRoomName(cfg.DefaultRoom)
+	if err != nil {
+		slog.Warn("Invalid default room, falling back", "room", cfg.DefaultRoom, "fallback", DefaultRoom, "err", err)
+		defaultRoom = DefaultRoom
+	}
+	h := &Hub{
+		cfg:         cfg,
+		defaultRoom: defaultRoom,
+		users:       make(map[string]*User),
+		rooms:       make(map[string]*ChatRoom),
+		histories:   make(map[string]*history),
+		transcript:  transcript,
+		bans:        bans,
+		accounts:    accounts,
+		join:        make(chan *Guest, EventChannelSize),
+		lines:       make(chan Line, EventChannelSize),
+		userFail:    make(chan UserError, EventChannelSize),
+		requests:    make(chan hubRequest, EventChannelSize),
+		done:        make(chan struct{}),
+	}
+	if cfg.Federation.Name != "" {
+		h.fed = newFederation(cfg.Federation)
+	}
+	h.rooms[defaultRoom] = h.newRoom(defaultRoom)
+	return h
+}
+
+func (h *Hub) newRoom(name string) *ChatRoom {
+	room := NewChatRoom(name, h.history(name), h.transcript, h.cfg.HistoryReplay)
+	if h.fed != nil {
+		room.relay = h.relay
+	}
+	return room
+}
+
+func (h *Hub) history(room string) *history {
+	hist, ok := h.histories[room]
+	if !ok {
+		hist = newHistory(h.cfg.HistorySize, h.cfg.HistoryMaxAge.Duration)
+		h.histories[room] = hist
+	}
+	return hist
+}
+
+// Restore fills room histories from a loaded transcript. It must be
+// called before Run.
+func (h *Hub) Restore(messages []Message) {
+	for _, message := range messages {
+		h.history(message.Room).add(message)
+	}
+}
+
+func (h *Hub) Run(ctx context.Context) {
+	slog.Info("Hub started", "default_room", h.defaultRoom)
+	hubCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer close(h.done)
+
+	var idleTick <-chan time.Time
+	if h.cfg.IdleAfter.Duration > 0 || h.cfg.IdleTimeout.Duration > 0 {
+		ticker := time.NewTicker(idleCheckInterval)
+		defer ticker.Stop()
+		idleTick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Hub stopped")
+			return
+		case guest := <-h.join:
+			h.handleGuest(hubCtx, guest)
+		case line := <-h.lines:
+			h.handleLine(line)
+		case err := <-h.userFail:
+			h.handleUserError(err)
+		case req := <-h.requests:
+			req.handle(h)
+		case now := <-idleTick:
+			h.checkIdle(now)
+		}
+	}
+}
+
+func (h *Hub) AddGuest(guest *Guest) {
+	h.join <- guest
+}
+
+// do queues req for the event loop. It reports false if the Hub has
+// stopped, but true does not mean req will be handled.
+func (h *Hub) do(req hubRequest) bool {
+	select {
+	case h.requests <- req:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// ask runs req on the event loop and returns what it sends on reply. It
+// reports false if the Hub stopped without handling req.
+func ask[T any](h *Hub, req hubRequest, reply chan T) (T, bool) {
+	var zero T
+	if !h.do(req) {
+		return zero, false
+	}
+	select {
+	case v := <-reply:
+		return v, true
+	case <-h.done:
+	}
+	// Run may have handled req right before stopping.
+	select {
+	case v := <-reply:
+		return v, true
+	default:
+		return zero, false
+	}
+}
+
+type nameQuery struct {
+	name  string
+	reply chan bool
+}
+
+func (q nameQuery) handle(h *Hub) {
+	_, taken := h.lookup(q.name)
+	q.reply <- taken
+}
+
+// NameTaken reports whether a user with the name, or one too like it, is
+// connected. The name may be taken by the time the guest joins, which the
+// Hub still checks.
+func (h *Hub) NameTaken(name string) bool {
+	q := nameQuery{name: name, reply: make(chan bool, 1)}
+	taken, ok := ask(h, q, q.reply)
+	return taken || !ok
+}
+
+func (h *Hub) handleGuest(ctx context.Context, guest *Guest) {
+	guest.logger.Info("Guest joined, checking name")
+
+	if _, present := h.lookup(guest.Name); present {
+		// Off the event loop, as the guest may not be reading.
+		go guest.Reject("Name already taken. Sorry.")
+		return
+	}
+	user := NewUser(guest, h.cfg)
+
+	go user.Run(ctx, h.lines, h.userFail)
+
+	user.oper = h.isOperator(user)
+	h.users[nameKey(user.Name)] = user
+	chatMetrics.Active.Inc()
+	h.joinRoom(user, h.defaultRoom)
+}
+
+// isOperator reports whether the user gets operator rights on joining.
+// The name must be listed and logged in to, or anyone could take it
+// first.
+func (h *Hub) isOperator(user *User) bool {
+	if user.account == "" || nameKey(user.account) != nameKey(user.Name) {
+		return false
+	}
+	return slices.ContainsFunc(h.cfg.Operators, func(name string) bool {
+		return nameKey(name) == nameKey(user.Name)
+	})
+}
+
+// joinRoom adds the user to the room, creating it if needed, and makes it
+// the user's current room.
+func (h *Hub) joinRoom(user *User, name string) {
+	room, ok := h.rooms[name]
+	if !ok {
+		room = h.newRoom(name)
+		h.rooms[name] = room
+		user.logger.Info("Room created", "room", name)
+	}
+	if !room.Has(user) {
+		room.add(user)
+	}
+	user.room = room
+}
+
+// partRoom removes the user from the room. Rooms other than the default
+// one are dropped once empty.
+func (h *Hub) partRoom(user *User, room *ChatRoom, notice string) {
+	room.remove(user, notice)
+	if room.Len() == 0 && room.Name != h.defaultRoom {
+		delete(h.rooms, room.Name)
+	}
+	if user.room != room {
+		return
+	}
+	user.room = nil
+	if next, ok := user.rooms[h.defaultRoom]; ok {
+		user.room = next
+	} else if names := roomNames(user.rooms); len(names) > 0 {
+		user.room = user.rooms[names[0]]
+	}
+}
+
+func (h *Hub) handleLine(line Line) {
+	user := line.User
+	if !h.present(user) {
+		return
+	}
+	if line.Notice != "" {
+		user.Deliver(notice("%s", line.Notice))
+		return
+	}
+	user.lastSeen = time.Now()
+	// The \r of CRLF clients is a line ending, not text to sanitize.
+	user.codec.handle(h, user, sanitizeText(strings.TrimSuffix(line.Text, "\r")))
+}
+
+// ircFormatting are the IRC codes for CTCP and text styles, which are
+// harmless to other clients.
+const ircFormatting = "\x01\x02\x03\x04\x0f\x11\x16\x1d\x1e\x1f"
+
+// sanitizeText turns control characters into spaces before any codec sees
+// the text, so no client can forge lines or terminal escapes for others.
+func sanitizeText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && !strings.ContainsRune(ircFormatting, r) {
+			return ' '
+		}
+		return r
+	}, text)
+}
+
+var (
+	errNoSuchUser = errors.New("no such user")
+	errMuted      = errors.New("sender is muted")
+)
+
+// sendPrivate delivers a private message from user to the user named to
+// and echoes it back to the sender.
+func (h *Hub) sendPrivate(user *User, to, text string) error {
+	target, ok := h.lookup(to)
+	if !ok {
+		return errNoSuchUser
+	}
+	if user.isMuted(time.Now()) {
+		return errMuted
+	}
+	msg := Message{Kind: PrivateMessage, From: user.Name, To: target.Name, Text: text, Time: time.Now()}
+	chatMetrics.Read(len(text) + 1)
+	user.sent++
+	user.lastActive = msg.Time
+	target.Deliver(msg)
+	if target != user {
+		user.Deliver(msg)
+	}
+	return nil
+}
+
+// lookup finds the user by name, ignoring case and lookalike letters.
+func (h *Hub) lookup(name string) (*User, bool) {
+	user, ok := h.users[nameKey(name)]
+	return user, ok
+}
+
+// present reports whether the user is still connected under its name.
+func (h *Hub) present(user *User) bool {
+	other, ok := h.lookup(user.Name)
+	return ok && other == user
+}
+
+func (h *Hub) reply(user *User, format string, args ...any) {
+	user.Deliver(notice(format, args...))
+}
+
+func (h *Hub) handleUserError(err UserError) {
+	if !errors.Is(err.Err, io.EOF) {
+		chatMetrics.Errors.Inc()
+	}
+	user := err.User
+	if !h.present(user) {
+		return
+	}
+	notice := leftNotice(user.Name, "")
+	switch {
+	case errors.Is(err.Err, ErrTooSlow):
+		notice = fmt.Sprintf("%s was disconnected (too slow)", user.Name)
+	case errors.Is(err.Err, ErrFlooding):
+		notice = fmt.Sprintf("%s was disconnected (flooding)", user.Name)
+	}
+	h.removeUser(user, notice)
+}
+
+func (h *Hub) kick(user *User, notice, farewell string) {
+	user.logger.Info("Kicking user", "notice", notice)
+	h.reply(user, "%s", farewell)
+	h.removeUser(user, notice)
+	user.Quit()
+}
+
+func (h *Hub) removeUser(user *User, notice string) {
+	for _, name := range roomNames(user.rooms) {
+		h.partRoom(user, user.rooms[name], notice)
+	}
+	delete(h.users, nameKey(user.Name))
+	// Bots and remote users have no connection.
+	if user.conn != nil {
+		chatMetrics.Active.Dec()
+	}
+}
+
+func leftNotice(name, reason string) string {
+	if reason == "" {
+		return fmt.Sprintf("%s left", name)
+	}
+	return fmt.Sprintf("%s left (%s)", name, reason)
+}
@@ -0,0 +1,98 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestSanitizeText tests that control characters become spaces while IRC
+// formatting survives
+func TestSanitizeText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"hello", "hello"},
+		{"hi\r:admin!admin@phchat PRIVMSG #general :owned", "hi :admin!admin@phchat PRIVMSG #general :owned"},
+		{"a\nb\x00c", "a b c"},
+		{"\x1b[2Jclear", " [2Jclear"},
+		{"tab\there", "tab here"},
+		{"\x01ACTION waves\x01", "\x01ACTION waves\x01"},
+		{"\x02bold\x02 \x0304red", "\x02bold\x02 \x0304red"},
+		{"c1\u009bcsi", "c1 csi"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeText(tt.text); got != tt.want {
+			t.Errorf("Expected %q for %q, got %q", tt.want, tt.text, got)
+		}
+	}
+}
+
+// TestCheckIdle tests that rooms are told when a user goes idle and comes
+// back, but not while it is away
+func TestCheckIdle(t *testing.T) {
+	hub := NewHub(DefaultConfig(), nil, nil, nil)
+	users := make(map[string]*User)
+	for _, name := range []string{"alice", "bob"} {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+		guest := NewGuest(server, nil, lineProtocol{}, lineProtocol{}.newCodec(hub.defaultRoom))
+		guest.Name = name
+		user := NewUser(guest, hub.cfg)
+		hub.users[nameKey(name)] = user
+		hub.joinRoom(user, hub.defaultRoom)
+		users[name] = user
+	}
+	alice, bob := users["alice"], users["bob"]
+	bob.queue.drain()
+
+	now := time.Now()
+	steps := []struct {
+		lastActive time.Time
+		away       bool
+		want       []string
+	}{
+		{now, false, nil},
+		{now.Add(-DefaultIdleAfter), false, []string{"* alice is idle"}},
+		{now.Add(-DefaultIdleAfter), false, nil},
+		{now, false, []string{"* alice is no longer idle"}},
+		{now.Add(-DefaultIdleAfter), true, nil},
+	}
+	for i, step := range steps {
+		alice.lastActive, alice.away = step.lastActive, step.away
+		hub.checkIdle(now)
+		if got := bob.queue.drain(); !slices.Equal(got, step.want) {
+			t.Errorf("Expected %q at step %d, got %q", step.want, i, got)
+		}
+	}
+}
+
+// TestRejectTakenName tests that a guest taking a name in use is rejected
+// without the Hub waiting for it to read
+func TestRejectTakenName(t *testing.T) {
+	hub := NewHub(DefaultConfig(), nil, nil, nil)
+	hub.users[nameKey("alice")] = &User{Name: "alice"}
+	server, client := net.Pipe()
+	defer client.Close()
+	guest := NewGuest(server, nil, lineProtocol{}, lineProtocol{}.newCodec(hub.defaultRoom))
+	guest.Name = "Alice"
+
+	done := make(chan struct{})
+	go func() {
+		hub.handleGuest(context.Background(), guest)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the Hub not to wait for the guest")
+	}
+	if line, err := bufio.NewReader(client).ReadString('\n'); line != "Name already taken. Sorry.\n" {
+		t.Errorf("Expected the name to be refused, got %q (%v)", line, err)
+	}
+}
@@ -0,0 +1,393 @@
+package chat
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/insomnes/protohackers/pkg/lineio"
+)
+
+// ircServerName is the server prefix of replies and the host part of user
+// masks.
+const ircServerName = "phchat"
+
+const ctcpAction = "\x01ACTION "
+
+// parseIRC splits a client line into its upper-cased command and its
+// parameters. A source prefix is ignored and a parameter starting with
+// ":" takes the rest of the line.
+func parseIRC(line string) (string, []string) {
+	line = strings.TrimSuffix(line, "\r")
+	if strings.HasPrefix(line, ":") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	var params []string
+	for {
+		line = strings.TrimLeft(line, " ")
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, ":") {
+			params = append(params, line[1:])
+			break
+		}
+		param, rest, _ := strings.Cut(line, " ")
+		params = append(params, param)
+		line = rest
+	}
+	if len(params) == 0 {
+		return "", nil
+	}
+	return strings.ToUpper(params[0]), params[1:]
+}
+
+// ircLine terminates a line with the CR of CRLF; the newline is added on
+// write.
+func ircLine(format string, args ...any) string {
+	return fmt.Sprintf(format, args...) + "\r"
+}
+
+func ircNumeric(nick, code, format string, args ...any) string {
+	return ircLine(":%s %s %s %s", ircServerName, code, nick, fmt.Sprintf(format, args...))
+}
+
+func ircMask(nick string) string {
+	return fmt.Sprintf("%s!%s@%s", nick, nick, ircServerName)
+}
+
+// ircProtocol speaks enough of RFC 2812 for common IRC clients. Rooms are
+// channels and chat commands unknown to IRC are passed through, so
+// "/rooms" or "/kick" typed in a client still work.
+type ircProtocol struct{}
+
+// greet runs the NICK/USER registration. Registered names need their
+// password in PASS.
+func (ircProtocol) greet(g *Guest) bool {
+	g.Conn.SetReadDeadline(time.Now().Add(nameTimeout))
+	nick, password, registered := "", "", false
+	for nick == "" || !registered {
+		line, err := g.reader.ReadLine()
+		if errors.Is(err, lineio.ErrLineTooLong) {
+			chatMetrics.Oversized.Inc()
+			g.Reject("Line too long")
+			return false
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				g.logger.Info("Guest closed connection")
+			} else {
+				g.logger.Warn("Guest cannot read", "err", err)
+			}
+			g.Close()
+			return false
+		}
+
+		target := cmp.Or(nick, "*")
+		cmd, params := parseIRC(line)
+		switch cmd {
+		case "":
+		case "CAP":
+			if len(params) > 0 && strings.EqualFold(params[0], "LS") {
+				err = g.send(ircLine(":%s CAP * LS :", ircServerName))
+			}
+		case "PASS":
+			if len(params) > 0 {
+				password = params[0]
+			}
+		case "NICK":
+			if len(params) == 0 {
+				err = g.send(ircNumeric(target, "431", ":No nickname given"))
+				break
+			}
+			normalized, invalid := g.names.check(params[0])
+			switch {
+			case invalid != nil:
+				err = g.send(ircNumeric(target, "432", "%s :Erroneous nickname: %v", params[0], invalid))
+			case g.nameTaken(normalized):
+				err = g.send(ircNumeric(target, "433", "%s :Nickname is already in use", params[0]))
+			default:
+				nick = normalized
+			}
+		case "USER":
+			if len(params) < 4 {
+				err = g.send(ircNumeric(target, "461", "USER :Not enough parameters"))
+			} else {
+				registered = true
+			}
+		case "PING":
+			err = g.send(ircLine(":%s PONG %s :%s", ircServerName, ircServerName, strings.Join(params, " ")))
+		case "QUIT":
+			g.Reject("Closing link")
+			return false
+		default:
+			err = g.send(ircNumeric(target, "451", ":You have not registered"))
+		}
+		if err != nil {
+			return false
+		}
+	}
+
+	if g.accounts.Registered(nick) {
+		joined, ok := g.login(nick, password)
+		if !ok {
+			return false
+		}
+		if joined != nick {
+			if err := g.send(ircNumeric(nick, "464", ":Password incorrect, joining as %s", joined)); err != nil {
+				return false
+			}
+			nick = joined
+		}
+	}
+
+	welcome := []string{
+		ircNumeric(nick, "001", ":Welcome to the phchat IRC gateway %s", ircMask(nick)),
+		ircNumeric(nick, "002", ":Your host is %s", ircServerName),
+		ircNumeric(nick, "003", ":This server speaks a subset of RFC 2812"),
+		ircNumeric(nick, "004", "%s phchat o o", ircServerName),
+		ircNumeric(nick, "422", ":MOTD File is missing"),
+	}
+	for _, line := range welcome {
+		if err := g.send(line); err != nil {
+			return false
+		}
+	}
+	g.Name = nick
+	g.Conn.SetReadDeadline(time.Time{})
+	return true
+}
+
+func (ircProtocol) newCodec(string) codec {
+	return ircCodec{}
+}
+
+type ircCodec struct{}
+
+func (ircCodec) handle(h *Hub, user *User, text string) {
+	nick := user.Name
+	numeric := func(code, format string, args ...any) {
+		user.Send(ircNumeric(nick, code, format, args...))
+	}
+
+	cmd, params := parseIRC(text)
+	switch cmd {
+	case "":
+	case "PING":
+		user.Send(ircLine(":%s PONG %s :%s", ircServerName, ircServerName, strings.Join(params, " ")))
+	case "PONG", "CAP":
+	case "JOIN":
+		if len(params) == 0 {
+			numeric("461", "JOIN :Not enough parameters")
+			return
+		}
+		if params[0] == "0" {
+			for _, name := range roomNames(user.rooms) {
+				h.partRoom(user, user.rooms[name], leftNotice(nick, ""))
+			}
+			return
+		}
+		for _, channel := range strings.Split(params[0], ",") {
+			name, err := normalizeRoomName(channel)
+			if err != nil {
+				numeric("403", "%s :No such channel", channel)
+				continue
+			}
+			h.joinRoom(user, name)
+		}
+	case "PART":
+		if len(params) == 0 {
+			numeric("461", "PART :Not enough parameters")
+			return
+		}
+		reason := ""
+		if len(params) > 1 {
+			reason = params[1]
+		}
+		for _, channel := range strings.Split(params[0], ",") {
+			room := ircRoom(user, channel)
+			if room == nil {
+				numeric("442", "%s :You're not on that channel", channel)
+				continue
+			}
+			h.partRoom(user, room, leftNotice(nick, reason))
+		}
+	case "PRIVMSG", "NOTICE":
+		// Errors are never sent in reply to a NOTICE.
+		quiet := cmd == "NOTICE"
+		if len(params) < 2 || params[1] == "" {
+			if !quiet {
+				numeric("412", ":No text to send")
+			}
+			return
+		}
+		target, text := params[0], params[1]
+		if strings.HasPrefix(target, "#") {
+			room := ircRoom(user, target)
+			if room == nil {
+				if !quiet {
+					numeric("404", "%s :Cannot send to channel", target)
+				}
+				return
+			}
+			message := Message{From: nick, Text: text}
+			if action, ok := strings.CutPrefix(text, ctcpAction); ok {
+				message = Message{Kind: ActionMessage, From: nick, Text: strings.TrimSuffix(action, "\x01")}
+			}
+			room.handleMessage(message)
+			return
+		}
+		switch err := h.sendPrivate(user, target, text); {
+		case errors.Is(err, errNoSuchUser) && !quiet:
+			numeric("401", "%s :No such nick/channel", target)
+		case errors.Is(err, errMuted):
+			h.reply(user, "You are muted, message not sent")
+		case err == nil && !quiet:
+			if other, _ := h.lookup(target); other.away {
+				numeric("301", "%s :%s", other.Name, other.awayText)
+			}
+		}
+	case "NAMES":
+		channels := []string{}
+		if len(params) > 0 {
+			channels = strings.Split(params[0], ",")
+		} else if user.room != nil {
+			channels = append(channels, "#"+user.room.Name)
+		}
+		for _, channel := range channels {
+			if room := ircRoom(user, channel); room != nil {
+				room.send(user, room.names())
+				continue
+			}
+			numeric("366", "%s :End of /NAMES list.", channel)
+		}
+	case "WHO":
+		mask := "*"
+		if len(params) > 0 {
+			mask = params[0]
+		}
+		numeric("315", "%s :End of /WHO list.", mask)
+	case "MODE":
+		switch {
+		case len(params) == 0:
+			numeric("461", "MODE :Not enough parameters")
+		case len(params) > 1:
+		case strings.HasPrefix(params[0], "#"):
+			numeric("324", "%s +", params[0])
+		default:
+			numeric("221", "+")
+		}
+	case "TOPIC":
+		if len(params) == 0 {
+			numeric("461", "TOPIC :Not enough parameters")
+			return
+		}
+		numeric("331", "%s :No topic is set", params[0])
+	case "NICK":
+		if len(params) == 0 {
+			numeric("431", ":No nickname given")
+			return
+		}
+		switch err := h.rename(user, params[0]); {
+		case errors.Is(err, errNameTaken):
+			numeric("433", "%s :Nickname is already in use", params[0])
+		case errors.Is(err, errNameBanned):
+			numeric("432", "%s :Nickname is banned", params[0])
+		case err != nil:
+			numeric("432", "%s :Erroneous nickname: %v", params[0], err)
+		}
+	case "AWAY":
+		if len(params) > 0 && params[0] != "" {
+			h.setAway(user, true, params[0])
+			numeric("306", ":You have been marked as being away")
+			return
+		}
+		h.setAway(user, false, "")
+		numeric("305", ":You are no longer marked as being away")
+	case "USER", "PASS":
+		numeric("462", ":You may not reregister")
+	case "OPER":
+		if len(params) < 2 {
+			numeric("461", "OPER :Not enough parameters")
+			return
+		}
+		h.handleCommand(user, "/oper "+params[1])
+	case "KICK":
+		if len(params) < 2 {
+			numeric("461", "KICK :Not enough parameters")
+			return
+		}
+		h.handleCommand(user, strings.Join(append([]string{"/kick"}, params[1:]...), " "))
+	case "QUIT":
+		reason := ""
+		if len(params) > 0 {
+			reason = params[0]
+		}
+		h.removeUser(user, leftNotice(nick, reason))
+		user.Send(ircLine("ERROR :Closing link (%s)", cmp.Or(reason, "Quit")))
+		user.Quit()
+	default:
+		if _, ok := commands[strings.ToLower(cmd)]; ok {
+			h.handleCommand(user, strings.Join(append([]string{"/" + strings.ToLower(cmd)}, params...), " "))
+			return
+		}
+		numeric("421", "%s :Unknown command", cmd)
+	}
+}
+
+// ircRoom returns the room named by channel if the user is in it.
+func ircRoom(user *User, channel string) *ChatRoom {
+	name, err := normalizeRoomName(channel)
+	if err != nil {
+		return nil
+	}
+	return user.rooms[name]
+}
+
+func (ircCodec) format(user *User, m Message) []string {
+	channel := "#" + m.Room
+	switch {
+	case m.History:
+		return []string{ircLine(":%s NOTICE %s :[%s] %s", ircServerName, channel, m.Time.Format(time.TimeOnly), m)}
+	case m.Kind == NickMessage:
+		return []string{ircLine(":%s NICK :%s", ircMask(m.Subject), m.To)}
+	case m.Kind == JoinMessage:
+		return []string{ircLine(":%s JOIN %s", ircMask(m.Subject), channel)}
+	case m.Kind == PartMessage:
+		return []string{ircLine(":%s PART %s :%s", ircMask(m.Subject), channel, m.Text)}
+	case m.Kind == NamesMessage:
+		names := m.Names
+		if !slices.Contains(names, user.Name) {
+			// A joining user is listed before being added to the room.
+			names = append(slices.Clone(names), user.Name)
+			slices.Sort(names)
+		}
+		return []string{
+			ircNumeric(user.Name, "353", "= %s :%s", channel, strings.Join(names, " ")),
+			ircNumeric(user.Name, "366", "%s :End of /NAMES list.", channel),
+		}
+	case m.Kind == PrivateMessage:
+		if m.From == user.Name {
+			// IRC clients echo their own messages.
+			return nil
+		}
+		return []string{ircLine(":%s PRIVMSG %s :%s", ircMask(m.From), m.To, m.Text)}
+	case m.Kind == ActionMessage:
+		return []string{ircLine(":%s PRIVMSG %s :%s%s\x01", ircMask(m.From), channel, ctcpAction, m.Text)}
+	case m.From == "":
+		target := user.Name
+		if m.Room != "" {
+			target = channel
+		}
+		return []string{ircLine(":%s NOTICE %s :%s", ircServerName, target, m.Text)}
+	}
+	return []string{ircLine(":%s PRIVMSG %s :%s", ircMask(m.From), channel, m.Text)}
+}
+
+func (ircCodec) reject(reason string) string {
+	return ircLine("ERROR :%s", strings.TrimPrefix(reason, "* "))
+}
@@ -0,0 +1,186 @@
+package chat
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/insomnes/protohackers/pkg/lineio"
+)
+
+// TestParseIRC tests prefixes, trailing parameters and command case
+func TestParseIRC(t *testing.T) {
+	tests := []struct {
+		line   string
+		cmd    string
+		params []string
+	}{
+		{"NICK alice\r", "NICK", []string{"alice"}},
+		{"privmsg #lobby :hello  there", "PRIVMSG", []string{"#lobby", "hello  there"}},
+		{":alice!a@host PART #dev :", "PART", []string{"#dev", ""}},
+		{"USER a 0 *  :Real Name", "USER", []string{"a", "0", "*", "Real Name"}},
+		{"  ", "", nil},
+	}
+	for _, tt := range tests {
+		cmd, params := parseIRC(tt.line)
+		if cmd != tt.cmd || !slices.Equal(params, tt.params) {
+			t.Errorf("Expected %s %q for %q, got %s %q", tt.cmd, tt.params, tt.line, cmd, params)
+		}
+	}
+}
+
+// ircGuest starts the IRC greeting of a guest and returns the client end
+// and the greeting's result.
+func ircGuest(t *testing.T, accounts *AccountStore, policy LoginPolicy) (net.Conn, *bufio.Reader, *Guest, chan bool) {
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	reader := lineio.NewReader(server, DefaultMaxMessageSize, lineio.Reject)
+	g := NewGuest(server, reader, ircProtocol{}, ircCodec{})
+	g.names = DefaultNamePolicy()
+	g.accounts = accounts
+	g.loginPolicy = policy
+	g.nameTaken = func(name string) bool { return name == "taken" }
+	result := make(chan bool, 1)
+	go func() { result <- ircProtocol{}.greet(g) }()
+	return client, bufio.NewReader(client), g, result
+}
+
+// TestIRCGreet tests the registration numerics and logging in with PASS
+func TestIRCGreet(t *testing.T) {
+	accounts, err := LoadAccounts(filepath.Join(t.TempDir(), "accounts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts.iterations = 1
+	if err := accounts.Register("alice", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	client, replies, g, result := ircGuest(t, accounts, LoginReject)
+	exchange := []struct {
+		line  string
+		reply string
+	}{
+		{"PRIVMSG #general :hi", ":phchat 451 * :You have not registered"},
+		{"NICK", ":phchat 431 * :No nickname given"},
+		{"NICK admin", ":phchat 432 * admin :Erroneous nickname: " + errNameReserved.Error()},
+		{"NICK taken", ":phchat 433 * taken :Nickname is already in use"},
+		{"PASS :correct horse", ""},
+		{"NICK Alice", ""},
+		{"USER alice 0 * :Alice", ":phchat 001 Alice :Welcome to the phchat IRC gateway Alice!Alice@phchat"},
+	}
+	for _, ex := range exchange {
+		if _, err := client.Write([]byte(ex.line + "\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		if ex.reply == "" {
+			continue
+		}
+		reply, err := replies.ReadString('\n')
+		if err != nil || reply != ex.reply+"\r\n" {
+			t.Errorf("Expected %q after %s, got %q (%v)", ex.reply, ex.line, reply, err)
+		}
+	}
+	for range 4 {
+		replies.ReadString('\n')
+	}
+	if !<-result || g.Name != "Alice" || g.account != "Alice" {
+		t.Errorf("Expected Alice to be logged in, got %q with account %q", g.Name, g.account)
+	}
+
+	client, replies, _, result = ircGuest(t, accounts, LoginReject)
+	client.Write([]byte("PASS wrong\r\nNICK alice\r\nUSER alice 0 * :Alice\r\n"))
+	if reply, _ := replies.ReadString('\n'); reply != "ERROR :Wrong password for alice\r\n" {
+		t.Errorf("Expected the wrong password to be rejected, got %q", reply)
+	}
+	if <-result {
+		t.Errorf("Expected the greeting to fail")
+	}
+}
+
+// TestIRCFormat tests the numerics and commands messages are rendered as
+func TestIRCFormat(t *testing.T) {
+	user := &User{Name: "alice"}
+	tests := []struct {
+		message Message
+		want    []string
+	}{
+		{
+			Message{Kind: NamesMessage, Room: "dev", Names: []string{"bob"}},
+			[]string{":phchat 353 alice = #dev :alice bob\r", ":phchat 366 alice #dev :End of /NAMES list.\r"},
+		},
+		{Message{From: "bob", Room: "dev", Text: "hi"}, []string{":bob!bob@phchat PRIVMSG #dev :hi\r"}},
+		{Message{Kind: ActionMessage, From: "bob", Room: "dev", Text: "waves"}, []string{":bob!bob@phchat PRIVMSG #dev :\x01ACTION waves\x01\r"}},
+		{Message{Kind: PrivateMessage, From: "bob", To: "alice", Text: "psst"}, []string{":bob!bob@phchat PRIVMSG alice :psst\r"}},
+		{Message{Kind: PrivateMessage, From: "alice", To: "bob", Text: "psst"}, nil},
+		{Message{Kind: JoinMessage, Subject: "bob", Room: "dev"}, []string{":bob!bob@phchat JOIN #dev\r"}},
+		{Message{Kind: PartMessage, Subject: "bob", Room: "dev", Text: "bye"}, []string{":bob!bob@phchat PART #dev :bye\r"}},
+		{Message{Room: "dev", Text: "* hello"}, []string{":phchat NOTICE #dev :* hello\r"}},
+	}
+	for _, tt := range tests {
+		if got := (ircCodec{}).format(user, tt.message); !slices.Equal(got, tt.want) {
+			t.Errorf("Expected %q for %+v, got %q", tt.want, tt.message, got)
+		}
+	}
+}
+
+// TestIRCJoinZero tests that JOIN 0 parts every room
+func TestIRCJoinZero(t *testing.T) {
+	hub := NewHub(DefaultConfig(), nil, nil, nil)
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	guest := NewGuest(server, nil, ircProtocol{}, ircCodec{})
+	guest.Name = "alice"
+	user := NewUser(guest, hub.cfg)
+	hub.users[nameKey(user.Name)] = user
+	hub.joinRoom(user, hub.defaultRoom)
+	hub.joinRoom(user, "dev")
+	user.queue.drain()
+
+	user.codec.handle(hub, user, "JOIN 0")
+	if len(user.rooms) != 0 {
+		t.Errorf("Expected no rooms after JOIN 0, got %v", roomNames(user.rooms))
+	}
+	want := []string{":alice!alice@phchat PART #dev :alice left\r", ":alice!alice@phchat PART #lobby :alice left\r"}
+	if got := user.queue.drain(); !slices.Equal(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+// TestIRCAction tests what others get of a CTCP ACTION sent with CRLF
+func TestIRCAction(t *testing.T) {
+	hub := NewHub(DefaultConfig(), nil, nil, nil)
+	users := make(map[string]*User)
+	for name, proto := range map[string]protocol{"alice": ircProtocol{}, "bob": ircProtocol{}, "carol": lineProtocol{}} {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+		guest := NewGuest(server, nil, proto, proto.newCodec(hub.defaultRoom))
+		guest.Name = name
+		user := NewUser(guest, hub.cfg)
+		hub.users[nameKey(name)] = user
+		hub.joinRoom(user, hub.defaultRoom)
+		users[name] = user
+	}
+	for _, user := range users {
+		user.queue.drain()
+	}
+
+	hub.handleLine(Line{User: users["alice"], Text: "PRIVMSG #lobby :\x01ACTION waves\x01\r"})
+	want := map[string][]string{
+		"alice": nil,
+		"bob":   {":alice!alice@phchat PRIVMSG #lobby :\x01ACTION waves\x01\r"},
+		"carol": {"* alice waves"},
+	}
+	for name, lines := range want {
+		if got := users[name].queue.drain(); !slices.Equal(got, lines) {
+			t.Errorf("Expected %q for %s, got %q", lines, name, got)
+		}
+	}
+}
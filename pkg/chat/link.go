@@ -0,0 +1,383 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/insomnes/protohackers/pkg/logging"
+)
+
+const (
+	maxFrameSize = 64 << 10
+	// linkQueueSize is how many frames may wait for a peer before the
+	// link is dropped as too slow.
+	linkQueueSize    = 1024
+	handshakeTimeout = 10 * time.Second
+	linkPingInterval = 30 * time.Second
+	linkReadTimeout  = 3 * linkPingInterval
+	linkWriteTimeout = 10 * time.Second
+	linkDialTimeout  = 10 * time.Second
+	minLinkBackoff   = time.Second
+	maxLinkBackoff   = time.Minute
+	nonceSize        = 16
+)
+
+var (
+	errFrameTooLarge = errors.New("frame too large")
+	errWrongSecret   = errors.New("wrong secret")
+)
+
+type frameType string
+
+const (
+	frameHello   frameType = "hello"
+	frameJoin    frameType = "join"
+	framePart    frameType = "part"
+	frameMessage frameType = "message"
+	frameNick    frameType = "nick"
+	framePrivate frameType = "private"
+	framePing    frameType = "ping"
+)
+
+// frame is the unit of the server-to-server protocol: a 4-byte big-endian
+// length followed by that many bytes of JSON.
+type frame struct {
+	Type frameType `json:"type"`
+	// ID is unique per creating server, so frames arriving twice over
+	// different links are dropped.
+	ID string `json:"id,omitempty"`
+	// Origin is the server of User.
+	Origin string `json:"origin,omitempty"`
+	// Server, Nonce and MAC introduce a peer in its hellos, see
+	// acceptHandshake.
+	Server string `json:"server,omitempty"`
+	Nonce  []byte `json:"nonce,omitempty"`
+	MAC    []byte `json:"mac,omitempty"`
+	Room   string `json:"room,omitempty"`
+	User   string `json:"user,omitempty"`
+	// To is the new name for nick frames and the recipient, on server
+	// Target, for private frames.
+	To     string      `json:"to,omitempty"`
+	Target string      `json:"target,omitempty"`
+	Kind   MessageKind `json:"kind,omitempty"`
+	Text   string      `json:"text,omitempty"`
+	Time   time.Time   `json:"time"`
+}
+
+func writeFrame(w io.Writer, f frame) error {
+	body, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if len(body) > maxFrameSize {
+		return errFrameTooLarge
+	}
+	buf := make([]byte, 4, 4+len(body))
+	binary.BigEndian.PutUint32(buf, uint32(len(body)))
+	_, err = w.Write(append(buf, body...))
+	return err
+}
+
+func readFrame(r io.Reader) (frame, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return frame{}, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxFrameSize {
+		return frame{}, errFrameTooLarge
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return frame{}, err
+	}
+	var f frame
+	if err := json.Unmarshal(body, &f); err != nil {
+		return frame{}, fmt.Errorf("invalid frame: %w", err)
+	}
+	return f, nil
+}
+
+func (f frame) check() error {
+	switch f.Type {
+	case frameJoin, framePart, frameMessage, frameNick, framePrivate:
+	default:
+		return fmt.Errorf("unexpected frame type %q", f.Type)
+	}
+	if err := validRemoteName(f.Origin); err != nil {
+		return fmt.Errorf("origin %q: %w", f.Origin, err)
+	}
+	if err := validRemoteName(f.User); err != nil {
+		return fmt.Errorf("user %q: %w", f.User, err)
+	}
+	switch f.Type {
+	case frameJoin, framePart, frameMessage:
+		if room, err := normalizeRoomName(f.Room); err != nil || room != f.Room {
+			return fmt.Errorf("invalid room %q", f.Room)
+		}
+	case framePrivate:
+		if err := validRemoteName(f.Target); err != nil {
+			return fmt.Errorf("target %q: %w", f.Target, err)
+		}
+		fallthrough
+	case frameNick:
+		if err := validRemoteName(f.To); err != nil {
+			return fmt.Errorf("to %q: %w", f.To, err)
+		}
+	}
+	if f.Type == frameMessage && f.Kind != ChatMessage && f.Kind != ActionMessage {
+		return fmt.Errorf("unexpected message kind %s", f.Kind)
+	}
+	if sanitizeText(f.Text) != f.Text {
+		return errors.New("text has control characters")
+	}
+	return nil
+}
+
+// handshake authenticates the peer on one end of a new link and returns
+// its name.
+type handshake func(conn net.Conn, cfg FederationConfig) (string, error)
+
+// acceptHandshake authenticates a peer that dialed in. It challenges the
+// peer with a nonce, and the peer answers with its own nonce and a MAC
+// over both made with the secret. Only then does it prove the same with
+// a MAC of its own, so strangers get nothing made with the secret.
+func acceptHandshake(conn net.Conn, cfg FederationConfig) (string, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	challenge := make([]byte, nonceSize)
+	rand.Read(challenge)
+	if err := writeFrame(conn, frame{Type: frameHello, Server: cfg.Name, Nonce: challenge, Time: time.Now()}); err != nil {
+		return "", err
+	}
+	hello, err := readHello(conn, cfg)
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal(hello.MAC, handshakeMAC(cfg.Secret, "dial", challenge, hello.Nonce, hello.Server, cfg.Name)) {
+		return "", errWrongSecret
+	}
+	mac := handshakeMAC(cfg.Secret, "accept", challenge, hello.Nonce, hello.Server, cfg.Name)
+	if err := writeFrame(conn, frame{Type: frameHello, Server: cfg.Name, MAC: mac, Time: time.Now()}); err != nil {
+		return "", err
+	}
+	return hello.Server, nil
+}
+
+// dialHandshake is the dialing end of acceptHandshake.
+func dialHandshake(conn net.Conn, cfg FederationConfig) (string, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	challenge, err := readHello(conn, cfg)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, nonceSize)
+	rand.Read(nonce)
+	mac := handshakeMAC(cfg.Secret, "dial", challenge.Nonce, nonce, cfg.Name, challenge.Server)
+	if err := writeFrame(conn, frame{Type: frameHello, Server: cfg.Name, Nonce: nonce, MAC: mac, Time: time.Now()}); err != nil {
+		return "", err
+	}
+	f, err := readFrame(conn)
+	if err != nil {
+		return "", err
+	}
+	want := handshakeMAC(cfg.Secret, "accept", challenge.Nonce, nonce, cfg.Name, challenge.Server)
+	if f.Type != frameHello || !hmac.Equal(f.MAC, want) {
+		return "", errWrongSecret
+	}
+	return challenge.Server, nil
+}
+
+func readHello(conn net.Conn, cfg FederationConfig) (frame, error) {
+	f, err := readFrame(conn)
+	switch {
+	case err != nil:
+		return frame{}, err
+	case f.Type != frameHello:
+		return frame{}, fmt.Errorf("expected hello, got %q", f.Type)
+	case validRemoteName(f.Server) != nil:
+		return frame{}, fmt.Errorf("invalid peer name %q", f.Server)
+	case f.Server == cfg.Name:
+		return frame{}, fmt.Errorf("peer uses our own name %s", f.Server)
+	case len(f.Nonce) != nonceSize:
+		return frame{}, errors.New("missing nonce")
+	}
+	return f, nil
+}
+
+// handshakeMAC binds the secret to both nonces, both names and which end
+// made it, so no MAC can be replayed or reflected back.
+func handshakeMAC(secret, end string, challenge, nonce []byte, dialer, acceptor string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	for _, part := range [][]byte{[]byte(end), challenge, nonce, []byte(dialer), []byte(acceptor)} {
+		mac.Write(binary.BigEndian.AppendUint32(nil, uint32(len(part))))
+		mac.Write(part)
+	}
+	return mac.Sum(nil)
+}
+
+type link struct {
+	peer      string
+	conn      net.Conn
+	out       chan frame
+	closed    chan struct{}
+	closeOnce sync.Once
+	logger    *slog.Logger
+}
+
+func newLink(peer string, conn net.Conn) *link {
+	logger := logging.ForConn(slog.Default(), logging.NewConnID(), conn.RemoteAddr().String())
+	return &link{
+		peer:   peer,
+		conn:   conn,
+		out:    make(chan frame, linkQueueSize),
+		closed: make(chan struct{}),
+		logger: logger.With("peer", peer),
+	}
+}
+
+// send queues f without blocking. A peer that falls that far behind is
+// disconnected and catches up through the burst when it relinks.
+func (l *link) send(f frame) {
+	select {
+	case <-l.closed:
+	case l.out <- f:
+	default:
+		l.logger.Warn("Federation peer is too slow, dropping link")
+		l.close()
+	}
+}
+
+func (l *link) close() {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.conn.Close()
+	})
+}
+
+func (l *link) writeLoop() {
+	ticker := time.NewTicker(linkPingInterval)
+	defer ticker.Stop()
+	for {
+		var f frame
+		select {
+		case <-l.closed:
+			return
+		case f = <-l.out:
+		case <-ticker.C:
+			f = frame{Type: framePing, Time: time.Now()}
+		}
+		l.conn.SetWriteDeadline(time.Now().Add(linkWriteTimeout))
+		if err := writeFrame(l.conn, f); err != nil {
+			l.logger.Warn("Federation write failed", "err", err)
+			l.close()
+			return
+		}
+		federationFrames.With("out").Inc()
+	}
+}
+
+func (l *link) readLoop(h *Hub) error {
+	reader := bufio.NewReader(l.conn)
+	for {
+		l.conn.SetReadDeadline(time.Now().Add(linkReadTimeout))
+		f, err := readFrame(reader)
+		if err != nil {
+			return err
+		}
+		federationFrames.With("in").Inc()
+		if f.Type == framePing {
+			continue
+		}
+		if !h.do(linkFrame{link: l, frame: f}) {
+			return errHubStopped
+		}
+	}
+}
+
+// serveLink runs a federation link over conn, once shake authenticated the
+// peer, until it fails or ctx is done. It reports whether the link came up
+// at all.
+func (h *Hub) serveLink(ctx context.Context, conn net.Conn, shake handshake) (bool, error) {
+	defer conn.Close()
+	peer, err := shake(conn, h.cfg.Federation)
+	if err != nil {
+		return false, fmt.Errorf("federation handshake: %w", err)
+	}
+	l := newLink(peer, conn)
+	defer l.close()
+	go l.writeLoop()
+
+	up := linkUp{link: l, reply: make(chan error, 1)}
+	err, ok := ask(h, up, up.reply)
+	if !ok {
+		return false, errHubStopped
+	}
+	if err != nil {
+		return false, err
+	}
+	defer h.do(linkDown{link: l})
+	stop := context.AfterFunc(ctx, l.close)
+	defer stop()
+	return true, l.readLoop(h)
+}
+
+func (h *Hub) acceptLinks(ctx context.Context, ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return fmt.Errorf("failed to accept federation link: %w", err)
+		}
+		go func() {
+			if _, err := h.serveLink(ctx, conn, acceptHandshake); err != nil && ctx.Err() == nil {
+				slog.Warn("Federation link closed", "remote", conn.RemoteAddr().String(), "err", err)
+			}
+		}()
+	}
+}
+
+// dialPeer keeps a link to addr up until ctx is done, redialing with
+// exponential backoff that starts over after every link that came up.
+func (h *Hub) dialPeer(ctx context.Context, addr string) {
+	var backoff time.Duration
+	dialer := net.Dialer{Timeout: linkDialTimeout}
+	for {
+		var linked bool
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			linked, err = h.serveLink(ctx, conn, dialHandshake)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		backoff = linkBackoff(backoff, linked)
+		slog.Warn("Federation link to peer failed, redialing", "peer", addr, "in", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// linkBackoff is the wait before redialing a peer, given the previous wait
+// or zero for the first attempt and whether the link came up in between.
+func linkBackoff(last time.Duration, linked bool) time.Duration {
+	if last == 0 || linked {
+		return minLinkBackoff
+	}
+	return min(2*last, maxLinkBackoff)
+}
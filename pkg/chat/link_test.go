@@ -0,0 +1,344 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFrameRoundTrip tests that frames survive the wire and oversized ones
+// are refused
+func TestFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	sent := frame{
+		Type:   frameMessage,
+		ID:     "office:1",
+		Origin: "office",
+		Room:   "lobby",
+		User:   "alice",
+		Kind:   ActionMessage,
+		Text:   "waves",
+		Time:   time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	if err := writeFrame(&buf, sent); err != nil {
+		t.Fatalf("Expected frame to be written, got %v", err)
+	}
+	got, err := readFrame(&buf)
+	if err != nil {
+		t.Fatalf("Expected frame to be read, got %v", err)
+	}
+	if !got.Time.Equal(sent.Time) {
+		t.Errorf("Expected time %v, got %v", sent.Time, got.Time)
+	}
+	got.Time = sent.Time
+	if !reflect.DeepEqual(got, sent) {
+		t.Errorf("Expected %+v, got %+v", sent, got)
+	}
+	if err := got.check(); err != nil {
+		t.Errorf("Expected valid frame, got %v", err)
+	}
+
+	big := sent
+	big.Text = strings.Repeat("x", maxFrameSize)
+	if err := writeFrame(&buf, big); !errors.Is(err, errFrameTooLarge) {
+		t.Errorf("Expected %v, got %v", errFrameTooLarge, err)
+	}
+}
+
+// TestRecentIDs tests that duplicates are reported until they are pushed
+// out by newer IDs
+func TestRecentIDs(t *testing.T) {
+	seen := newRecentIDs(2)
+	for _, id := range []string{"a:1", "a:2"} {
+		if !seen.add(id) {
+			t.Errorf("Expected %s to be new", id)
+		}
+	}
+	if seen.add("a:1") {
+		t.Errorf("Expected a:1 to be a duplicate")
+	}
+	seen.add("a:3")
+	if !seen.add("a:1") {
+		t.Errorf("Expected a:1 to be forgotten")
+	}
+}
+
+// TestHandshake tests that peers with the same secret link up and that a
+// peer without it learns nothing made with it
+func TestHandshake(t *testing.T) {
+	type result struct {
+		peer string
+		err  error
+	}
+	shake := func(dialer, acceptor FederationConfig) (result, result) {
+		d, a := net.Pipe()
+		defer d.Close()
+		defer a.Close()
+		accepted := make(chan result, 1)
+		go func() {
+			peer, err := acceptHandshake(a, acceptor)
+			a.Close()
+			accepted <- result{peer, err}
+		}()
+		peer, err := dialHandshake(d, dialer)
+		d.Close()
+		return result{peer, err}, <-accepted
+	}
+
+	office := FederationConfig{Name: "office", Secret: "s3cret"}
+	home := FederationConfig{Name: "home", Secret: "s3cret"}
+	dialed, accepted := shake(home, office)
+	if dialed.err != nil || dialed.peer != "office" || accepted.err != nil || accepted.peer != "home" {
+		t.Errorf("Expected home and office to link up, got %+v and %+v", dialed, accepted)
+	}
+
+	stranger := FederationConfig{Name: "home", Secret: "guess"}
+	if dialed, accepted = shake(stranger, office); dialed.err == nil || !errors.Is(accepted.err, errWrongSecret) {
+		t.Errorf("Expected the wrong secret to fail both ends, got %+v and %+v", dialed, accepted)
+	}
+
+	// A stranger sees only the challenge before the link is dropped.
+	d, a := net.Pipe()
+	defer d.Close()
+	go func() {
+		acceptHandshake(a, office)
+		a.Close()
+	}()
+	challenge, err := readFrame(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if challenge.MAC != nil || len(challenge.Nonce) != nonceSize {
+		t.Errorf("Expected a bare challenge, got %+v", challenge)
+	}
+	writeFrame(d, frame{Type: frameHello, Server: "home", Nonce: make([]byte, nonceSize), MAC: []byte("guess")})
+	if rest, _ := io.ReadAll(d); len(rest) != 0 {
+		t.Errorf("Expected nothing after a wrong MAC, got %q", rest)
+	}
+}
+
+// TestLinkBackoff tests that redials back off up to the limit and start
+// over after a link came up
+func TestLinkBackoff(t *testing.T) {
+	var backoff time.Duration
+	want := []time.Duration{1, 2, 4, 8, 16, 32, 60, 60}
+	for i, seconds := range want {
+		backoff = linkBackoff(backoff, false)
+		if backoff != seconds*time.Second {
+			t.Errorf("Expected redial %d after %v, got %v", i+1, seconds*time.Second, backoff)
+		}
+	}
+	if backoff = linkBackoff(backoff, true); backoff != minLinkBackoff {
+		t.Errorf("Expected %v after a link came up, got %v", minLinkBackoff, backoff)
+	}
+}
+
+// fedHub starts a Hub federated as name.
+func fedHub(ctx context.Context, name string) *Hub {
+	cfg := DefaultConfig()
+	cfg.Federation = FederationConfig{Name: name, Secret: "s3cret"}
+	bans, _ := LoadBanList("")
+	hub := NewHub(cfg, nil, bans, nil)
+	go hub.Run(ctx)
+	return hub
+}
+
+// linkHubs links dialer to acceptor and returns the dialer's end, which
+// drops the link when closed.
+func linkHubs(ctx context.Context, dialer, acceptor *Hub) net.Conn {
+	d, a := net.Pipe()
+	go dialer.serveLink(ctx, d, dialHandshake)
+	go acceptor.serveLink(ctx, a, acceptHandshake)
+	return d
+}
+
+type hubFunc struct {
+	f    func(h *Hub)
+	done chan struct{}
+}
+
+func (r hubFunc) handle(h *Hub) {
+	r.f(h)
+	close(r.done)
+}
+
+// eventually waits for cond to hold on the Hub's event loop.
+func eventually(t *testing.T, h *Hub, what string, cond func(h *Hub) bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(5 * time.Millisecond) {
+		var ok bool
+		r := hubFunc{f: func(h *Hub) { ok = cond(h) }, done: make(chan struct{})}
+		if h.do(r) {
+			<-r.done
+		}
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %s on %s", what, h.fed.cfg.Name)
+		}
+	}
+}
+
+// say has the bot named from say text in its rooms.
+func say(h *Hub, from, text string) {
+	r := hubFunc{f: func(h *Hub) {
+		user, _ := h.lookup(from)
+		botSay{user: user, kind: ChatMessage, text: text}.handle(h)
+	}, done: make(chan struct{})}
+	h.do(r)
+	<-r.done
+}
+
+func linked(peers ...string) func(h *Hub) bool {
+	return func(h *Hub) bool {
+		for _, peer := range peers {
+			if _, ok := h.fed.links[peer]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func hasUser(name string) func(h *Hub) bool {
+	return func(h *Hub) bool {
+		_, ok := h.lookup(name)
+		return ok
+	}
+}
+
+// said counts the messages with text in the Hub's default room.
+func said(h *Hub, text string) int {
+	n := 0
+	for _, m := range h.history(h.defaultRoom).last(h.cfg.HistorySize, time.Now()) {
+		if m.Kind == ChatMessage && m.Text == text {
+			n++
+		}
+	}
+	return n
+}
+
+// TestFederationLoop tests that messages go around a cycle of three
+// servers once and never come back to their origin
+func TestFederationLoop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, b, c := fedHub(ctx, "a"), fedHub(ctx, "b"), fedHub(ctx, "c")
+	linkHubs(ctx, a, b)
+	linkHubs(ctx, b, c)
+	linkHubs(ctx, c, a)
+	eventually(t, a, "links to b and c", linked("b", "c"))
+	eventually(t, b, "links to a and c", linked("a", "c"))
+	eventually(t, c, "links to a and b", linked("a", "b"))
+
+	carol := &testBot{name: "carol", seen: make(chan Message, 16)}
+	for hub, bot := range map[*Hub]*testBot{a: {name: "alice"}, b: {name: "bob"}, c: carol} {
+		if err := hub.AddBot(ctx, bot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, hub := range []*Hub{a, b, c} {
+		for _, name := range []string{"alice", "bob", "carol"} {
+			eventually(t, hub, name, hasUser(name))
+		}
+	}
+
+	say(a, "alice", "hi")
+	eventually(t, b, "hi from alice", func(h *Hub) bool { return said(h, "hi") > 0 })
+	// Both copies of hi reached c once these did, over b and over a.
+	say(b, "bob", "done")
+	say(a, "alice", "end")
+	var got []string
+	for !slices.Contains(got, "done") || !slices.Contains(got, "end") {
+		select {
+		case m := <-carol.seen:
+			got = append(got, m.Text)
+		case <-time.After(time.Second):
+			t.Fatalf("Expected done and end to reach carol, got %q", got)
+		}
+	}
+	if n := slices.Index(got, "hi"); n < 0 || slices.Contains(got[n+1:], "hi") {
+		t.Errorf("Expected hi to reach carol once, got %q", got)
+	}
+	for _, hub := range []*Hub{a, b, c} {
+		eventually(t, hub, "hi once", func(h *Hub) bool { return said(h, "hi") == 1 })
+		eventually(t, hub, "three users", func(h *Hub) bool { return len(h.users) == 3 })
+	}
+}
+
+// TestFederationNames tests that remote names colliding with local ones
+// are suffixed, also after renames
+func TestFederationNames(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, b := fedHub(ctx, "a"), fedHub(ctx, "b")
+	for _, name := range []string{"alice", "bob"} {
+		if err := b.AddBot(ctx, &testBot{name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	linkHubs(ctx, a, b)
+	if err := a.AddBot(ctx, &testBot{name: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, b, "alice_a", hasUser("alice_a"))
+	eventually(t, a, "bob", hasUser("bob"))
+
+	renamed := hubFunc{f: func(h *Hub) {
+		user, _ := h.lookup("alice")
+		if err := h.rename(user, "dave"); err != nil {
+			t.Errorf("Expected alice to become dave, got %v", err)
+		}
+	}, done: make(chan struct{})}
+	a.do(renamed)
+	<-renamed.done
+	eventually(t, b, "dave", hasUser("dave"))
+	eventually(t, b, "alice_a gone", func(h *Hub) bool { return !hasUser("alice_a")(h) })
+
+	// bob is taken by b's own bob, so a's dave shows up as bob_a.
+	nick := hubFunc{f: func(h *Hub) {
+		f := frame{Type: frameNick, ID: "a:test", Origin: "a", User: "dave", To: "bob", Time: time.Now()}
+		linkFrame{link: h.fed.links["a"], frame: f}.handle(h)
+	}, done: make(chan struct{})}
+	b.do(nick)
+	<-nick.done
+	eventually(t, b, "bob_a", hasUser("bob_a"))
+	eventually(t, b, "dave known as bob", func(h *Hub) bool {
+		user, ok := h.fed.remote[remoteKey{"a", "bob"}]
+		return ok && user.Name == "bob_a" && len(h.fed.remote) == 1
+	})
+}
+
+// TestFederationLinkDown tests that users behind a lost link leave on
+// every server
+func TestFederationLinkDown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, b, c := fedHub(ctx, "a"), fedHub(ctx, "b"), fedHub(ctx, "c")
+	ab := linkHubs(ctx, a, b)
+	linkHubs(ctx, b, c)
+	if err := a.AddBot(ctx, &testBot{name: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, c, "alice", hasUser("alice"))
+
+	ab.Close()
+	eventually(t, b, "the link to a gone", func(h *Hub) bool { return !linked("a")(h) })
+	for _, hub := range []*Hub{b, c} {
+		eventually(t, hub, "alice gone", func(h *Hub) bool {
+			return !hasUser("alice")(h) && len(h.fed.remote) == 0
+		})
+	}
+	eventually(t, c, "alice's part", func(h *Hub) bool {
+		last := h.history(h.defaultRoom).last(1, time.Now())
+		return len(last) == 1 && last[0].Kind == PartMessage && last[0].Text == "alice left (lost link to a)"
+	})
+}
@@ -0,0 +1,105 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type MessageKind int
+
+const (
+	// ChatMessage is a plain line; without From it is a system notice.
+	ChatMessage MessageKind = iota
+	ActionMessage
+	PrivateMessage
+	// JoinMessage and PartMessage are about Subject; Text is the notice.
+	JoinMessage
+	PartMessage
+	// NamesMessage lists the members of Room.
+	NamesMessage
+	// NickMessage tells that Subject is now known as To.
+	NickMessage
+)
+
+var messageKindNames = map[MessageKind]string{
+	ChatMessage:    "message",
+	ActionMessage:  "action",
+	PrivateMessage: "private",
+	JoinMessage:    "join",
+	PartMessage:    "part",
+	NamesMessage:   "names",
+	NickMessage:    "nick",
+}
+
+func (k MessageKind) String() string {
+	if name, ok := messageKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("MessageKind(%d)", int(k))
+}
+
+func (k MessageKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *MessageKind) UnmarshalText(text []byte) error {
+	for kind, name := range messageKindNames {
+		if name == string(text) {
+			*k = kind
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown message kind %q", text)
+}
+
+// Message is everything delivered to users. Codecs render it in the
+// user's protocol.
+type Message struct {
+	Kind    MessageKind
+	Room    string
+	From    string
+	To      string
+	Subject string
+	Text    string
+	Names   []string
+	// Status holds the presence of listed names that are away or idle,
+	// only for /who.
+	Status map[string]string
+	Time   time.Time
+	// History marks messages replayed from a room's history.
+	History bool
+	// Origin is the server a federated message came from, empty for
+	// local ones.
+	Origin string
+}
+
+// String renders the message for the plain line protocol.
+func (m Message) String() string {
+	switch {
+	case m.Kind == ActionMessage:
+		return fmt.Sprintf("* %s %s", m.From, m.Text)
+	case m.Kind == PrivateMessage:
+		return fmt.Sprintf("[%s -> %s] %s", m.From, m.To, m.Text)
+	case m.Kind == NamesMessage:
+		sb := strings.Builder{}
+		sb.WriteString("* Users in chatroom: ")
+		for _, name := range m.Names {
+			sb.WriteString(name)
+			if status, ok := m.Status[name]; ok {
+				fmt.Fprintf(&sb, " (%s)", status)
+			}
+			sb.WriteString(" ")
+		}
+		return sb.String()
+	case m.From == "":
+		return fmt.Sprintf("* %s", m.Text)
+	}
+	return fmt.Sprintf("[%s] %s", m.From, m.Text)
+}
+
+// notice is a system message for a single user or, with a room, for its
+// members.
+func notice(format string, args ...any) Message {
+	return Message{Text: fmt.Sprintf(format, args...)}
+}
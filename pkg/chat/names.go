@@ -0,0 +1,248 @@
+package chat
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	DefaultMinNameLength = 1
+	DefaultMaxNameLength = 20
+	// maxRemoteName bounds names learned from federated servers, which
+	// enforce their own policy.
+	maxRemoteName = 64
+)
+
+// DefaultReservedNames cannot be taken by users so nobody can pass for
+// the server or its admin API.
+var DefaultReservedNames = []string{adminName, "server"}
+
+var (
+	errNameEmpty     = errors.New("name cannot be empty")
+	errNameReserved  = errors.New("name is reserved")
+	errNameMixed     = errors.New("name cannot mix scripts")
+	errNameCombining = errors.New("name cannot contain combining marks, use precomposed characters")
+	errNameStart     = errors.New("name must start with a letter or digit")
+)
+
+// NameCharset is the class of characters names are made of.
+type NameCharset int
+
+const (
+	// NameLetters allows letters and digits of any script, one script per
+	// name.
+	NameLetters NameCharset = iota
+	// NameASCII allows only ASCII letters and digits.
+	NameASCII
+)
+
+func (c NameCharset) String() string {
+	return [...]string{"letters", "ascii"}[c]
+}
+
+func ParseNameCharset(s string) (NameCharset, error) {
+	switch strings.ToLower(s) {
+	case "letters":
+		return NameLetters, nil
+	case "ascii":
+		return NameASCII, nil
+	}
+	return 0, fmt.Errorf("unknown name charset %q", s)
+}
+
+func (c NameCharset) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *NameCharset) UnmarshalText(b []byte) error {
+	parsed, err := ParseNameCharset(string(b))
+	if err != nil {
+		return err
+	}
+	*c = parsed
+	return nil
+}
+
+// NamePolicy decides which names users may take. Names are unique and
+// reserved regardless of case and of letters from other scripts that
+// look like Latin ones.
+type NamePolicy struct {
+	// MinLength and MaxLength count characters, not bytes.
+	MinLength int         `json:"min_length"`
+	MaxLength int         `json:"max_length"`
+	Charset   NameCharset `json:"charset"`
+	// Punctuation lists characters allowed besides the charset, but not
+	// first, e.g. "-.". Characters with a meaning in the protocols, and
+	// "_" which suffixes the names of federated users, are not allowed.
+	Punctuation string   `json:"punctuation"`
+	Reserved    []string `json:"reserved"`
+}
+
+func DefaultNamePolicy() NamePolicy {
+	return NamePolicy{
+		MinLength: DefaultMinNameLength,
+		MaxLength: DefaultMaxNameLength,
+		Charset:   NameLetters,
+		Reserved:  DefaultReservedNames,
+	}
+}
+
+// reservedPunctuation cannot be allowed in names, see
+// NamePolicy.Punctuation.
+const reservedPunctuation = ":!@#,*_/"
+
+func (p NamePolicy) validate() error {
+	for _, r := range p.Punctuation {
+		if unicode.IsSpace(r) || !unicode.IsPunct(r) && !unicode.IsSymbol(r) || strings.ContainsRune(reservedPunctuation, r) {
+			return fmt.Errorf("names cannot contain %q", r)
+		}
+	}
+	if p.MaxLength != 0 && p.MaxLength < p.MinLength {
+		return fmt.Errorf("name length limits %d-%d are inverted", p.MinLength, p.MaxLength)
+	}
+	return nil
+}
+
+// check returns the name in normal form, or why it cannot be taken.
+func (p NamePolicy) check(name string) (string, error) {
+	name = normalizeName(name)
+	if name == "" {
+		return "", errNameEmpty
+	}
+	length := utf8.RuneCountInString(name)
+	if minLength := max(p.MinLength, 1); length < minLength {
+		return "", fmt.Errorf("name must be at least %d characters", minLength)
+	}
+	if maxLength := cmp.Or(p.MaxLength, DefaultMaxNameLength); length > maxLength {
+		return "", fmt.Errorf("name cannot be longer than %d characters", maxLength)
+	}
+
+	script := ""
+	for i, r := range name {
+		switch {
+		case unicode.Is(unicode.M, r):
+			return "", errNameCombining
+		case strings.ContainsRune(p.Punctuation, r):
+			if i == 0 {
+				return "", errNameStart
+			}
+			continue
+		case !p.allowed(r):
+			return "", p.charsetError()
+		}
+		if s := runeScript(r); s != "" {
+			if script != "" && s != script {
+				return "", errNameMixed
+			}
+			script = s
+		}
+	}
+
+	key := nameKey(name)
+	for _, reserved := range p.Reserved {
+		if nameKey(reserved) == key {
+			return "", errNameReserved
+		}
+	}
+	return name, nil
+}
+
+func (p NamePolicy) allowed(r rune) bool {
+	if r < utf8.RuneSelf {
+		return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9'
+	}
+	// Letters and digits without a script of their own, like the
+	// mathematical alphabets, only pass for ASCII ones.
+	return p.Charset == NameLetters && (unicode.IsLetter(r) || unicode.IsDigit(r)) && runeScript(r) != ""
+}
+
+func (p NamePolicy) charsetError() error {
+	what := "letters and digits"
+	if p.Charset == NameASCII {
+		what = "ASCII letters and digits"
+	}
+	if p.Punctuation != "" {
+		what += " and any of " + p.Punctuation
+	}
+	return fmt.Errorf("name can only contain %s", what)
+}
+
+// normalizeName trims the name and turns fullwidth forms into ASCII. The
+// standard library has no NFC, so decomposed characters are rejected by
+// check instead of composed here.
+func normalizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if '！' <= r && r <= '～' {
+			return r - '！' + '!'
+		}
+		return r
+	}, strings.TrimSpace(name))
+}
+
+// runeScript names the script of a letter or digit, empty for ones shared
+// by all scripts like ASCII digits. Scripts written together, like kana
+// and kanji, count as one.
+func runeScript(r rune) string {
+	switch {
+	case '0' <= r && r <= '9':
+		return ""
+	case r < utf8.RuneSelf:
+		return "Latin"
+	}
+	for name, table := range unicode.Scripts {
+		if name == "Common" || name == "Inherited" || !unicode.Is(table, r) {
+			continue
+		}
+		switch name {
+		case "Hiragana", "Katakana", "Hangul", "Bopomofo":
+			return "Han"
+		}
+		return name
+	}
+	return ""
+}
+
+// nameKey is what names are compared by: lowercased, with letters that
+// look like Latin ones, in either case, replaced by those.
+func nameKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		r = unicode.ToLower(r)
+		if latin, ok := lookalikes[r]; ok {
+			return latin
+		}
+		return r
+	}, normalizeName(name))
+}
+
+var lookalikes = map[rune]rune{
+	'0': 'o', '1': 'l',
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'һ': 'h', 'і': 'i', 'ј': 'j', 'к': 'k',
+	'ӏ': 'l', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'ԛ': 'q', 'ѕ': 's',
+	'т': 't', 'у': 'y', 'ԝ': 'w', 'х': 'x', 'ԁ': 'd', 'с': 'c',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'h', 'ι': 'i', 'κ': 'k', 'μ': 'm',
+	'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'ζ': 'z',
+}
+
+// validRemoteName checks names of federated servers and their users,
+// which passed their own server's policy: it only keeps out what would
+// break the protocols.
+func validRemoteName(name string) error {
+	if name == "" {
+		return errNameEmpty
+	}
+	if utf8.RuneCountInString(name) > maxRemoteName {
+		return fmt.Errorf("name cannot be longer than %d characters", maxRemoteName)
+	}
+	for _, r := range name {
+		if unicode.IsSpace(r) || !unicode.IsPrint(r) || strings.ContainsRune(reservedPunctuation, r) {
+			return fmt.Errorf("name cannot contain %q", r)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package chat
+
+import (
+	"testing"
+)
+
+// TestNamePolicy tests normalization and the reason each name is refused
+func TestNamePolicy(t *testing.T) {
+	policy := NamePolicy{
+		MinLength:   2,
+		MaxLength:   8,
+		Punctuation: "-",
+		Reserved:    []string{"admin"},
+	}
+	tests := []struct {
+		name   string
+		want   string
+		reason string
+	}{
+		{"alice", "alice", ""},
+		{"Ｂｏｂ", "Bob", ""},
+		{"Zoë", "Zoë", ""},
+		{"ジョン太郎", "ジョン太郎", ""},
+		{"a-b", "a-b", ""},
+		{"", "", errNameEmpty.Error()},
+		{"a", "", "name must be at least 2 characters"},
+		{"ëëëëëëëëë", "", "name cannot be longer than 8 characters"},
+		{"-ab", "", errNameStart.Error()},
+		{"a_b", "", "name can only contain letters and digits and any of -"},
+		{"Zoe\u0308", "", errNameCombining.Error()},
+		{"p\u0430ypal", "", errNameMixed.Error()},
+		{"ADMIN", "", errNameReserved.Error()},
+		{"\u0430dmin", "", errNameMixed.Error()},
+	}
+	for _, tt := range tests {
+		got, err := policy.check(tt.name)
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		}
+		if got != tt.want || reason != tt.reason {
+			t.Errorf("Expected %q, %q for %q, got %q, %q", tt.want, tt.reason, tt.name, got, reason)
+		}
+	}
+}
+
+// TestNameKey tests that names differing in case or lookalike letters
+// are the same
+func TestNameKey(t *testing.T) {
+	same := [][2]string{
+		{"Alice", "aLICE"},
+		{"alice", "\u0430l\u0456\u0441\u0435"},
+		{"bob0", "BOBO"},
+		{"Ｂｏｂ", "bob"},
+	}
+	for _, names := range same {
+		if nameKey(names[0]) != nameKey(names[1]) {
+			t.Errorf("Expected %q and %q to be the same name", names[0], names[1])
+		}
+	}
+	if nameKey("alice") == nameKey("alicia") {
+		t.Errorf("Expected alice and alicia to differ")
+	}
+}
@@ -0,0 +1,135 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultIdleAfter  = 10 * time.Minute
+	idleCheckInterval = time.Second
+)
+
+var (
+	errNameTaken  = errors.New("name is already taken")
+	errNameBanned = errors.New("name is banned")
+)
+
+// rename changes the user's name everywhere and tells everyone sharing a
+// room with it. The change is recorded in the history of those rooms.
+func (h *Hub) rename(user *User, name string) error {
+	name, err := h.cfg.Names.check(name)
+	if err != nil {
+		return err
+	}
+	if other, ok := h.lookup(name); ok && other != user {
+		return errNameTaken
+	}
+	if name == user.Name {
+		return nil
+	}
+	if h.accounts.Registered(name) && nameKey(user.account) != nameKey(name) {
+		return errNameRegistered
+	}
+	if _, banned := h.bans.Check(name, time.Now()); banned {
+		return errNameBanned
+	}
+	old := user.Name
+	h.renameUser(user, name)
+	h.relayNick(old, name)
+	return nil
+}
+
+// renameUser re-keys the user under name, which must be free, records the
+// change in its rooms and tells everyone sharing a room with it.
+func (h *Hub) renameUser(user *User, name string) {
+	old := user.Name
+	delete(h.users, nameKey(old))
+	h.users[nameKey(name)] = user
+	user.Name = name
+	message := Message{
+		Kind:    NickMessage,
+		Subject: old,
+		To:      name,
+		Text:    fmt.Sprintf("%s is now known as %s", old, name),
+		Time:    time.Now(),
+	}
+	for _, room := range user.rooms {
+		delete(room.users, old)
+		room.users[name] = user
+		room.record(message)
+	}
+	user.logger.Info("User renamed", "old", old, "new", name)
+	for _, peer := range h.peers(user) {
+		peer.Deliver(message)
+	}
+}
+
+// setAway marks the user away, or back when away is false, and tells
+// everyone sharing a room with it. It reports false when nothing changed.
+func (h *Hub) setAway(user *User, away bool, text string) bool {
+	if !away && !user.away {
+		return false
+	}
+	user.away, user.awayText = away, text
+	notice := fmt.Sprintf("%s is back", user.Name)
+	switch {
+	case away && text != "":
+		notice = fmt.Sprintf("%s is away (%s)", user.Name, text)
+	case away:
+		notice = fmt.Sprintf("%s is away", user.Name)
+	}
+	for _, peer := range h.peers(user) {
+		h.reply(peer, "%s", notice)
+	}
+	return true
+}
+
+// peers returns the user and everyone sharing a room with it, once each.
+func (h *Hub) peers(user *User) []*User {
+	seen := map[*User]bool{user: true}
+	peers := []*User{user}
+	for _, room := range user.rooms {
+		for _, member := range room.users {
+			if !seen[member] {
+				seen[member] = true
+				peers = append(peers, member)
+			}
+		}
+	}
+	return peers
+}
+
+// checkIdle tells rooms about users going idle or coming back, unless
+// they are away, and kicks users that sent nothing for the idle timeout.
+func (h *Hub) checkIdle(now time.Time) {
+	timeout := h.cfg.IdleTimeout.Duration
+	for _, user := range h.users {
+		if user.conn == nil {
+			continue
+		}
+		if timeout > 0 && now.Sub(user.lastSeen) >= timeout {
+			h.kick(user,
+				fmt.Sprintf("%s was disconnected (idle)", user.Name),
+				fmt.Sprintf("Disconnected after %s without activity", timeout),
+			)
+			continue
+		}
+		idle := user.isIdle(now)
+		if idle == user.idle {
+			continue
+		}
+		user.idle = idle
+		if user.away {
+			continue
+		}
+		notice := fmt.Sprintf("%s is no longer idle", user.Name)
+		if idle {
+			notice = fmt.Sprintf("%s is idle", user.Name)
+		}
+		for _, peer := range h.peers(user) {
+			h.reply(peer, "%s", notice)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/insomnes/protohackers/pkg/lineio"
+)
+
+const nameTimeout = 60 * time.Second
+
+// protocol is a client wire format: how guests are greeted and how the
+// lines and messages of users are translated.
+type protocol interface {
+	// greet reads the guest's name. It returns false once the guest has
+	// been rejected or has gone away.
+	greet(g *Guest) bool
+	newCodec(defaultRoom string) codec
+}
+
+type codec interface {
+	// handle acts on a line read from the user. It runs on the Hub event
+	// loop.
+	handle(h *Hub, user *User, text string)
+	// format renders a message as zero or more lines for the user.
+	format(user *User, m Message) []string
+	// reject renders the reason a client is turned away.
+	reject(reason string) string
+}
+
+// lineProtocol is the plain protohackers chat protocol.
+type lineProtocol struct{}
+
+func (lineProtocol) greet(g *Guest) bool {
+	err := g.send("Welcome! What is your name?")
+	if err != nil {
+		g.Close()
+		return false
+	}
+
+	g.Conn.SetReadDeadline(time.Now().Add(nameTimeout))
+	name, err := g.reader.ReadLine()
+	if errors.Is(err, lineio.ErrLineTooLong) {
+		chatMetrics.Oversized.Inc()
+		g.Reject("Invalid name: name is too long")
+		return false
+	}
+	if err != nil {
+		if err.Error() == "EOF" {
+			g.logger.Info("Guest closed connection")
+		} else {
+			g.logger.Warn("Guest cannot read", "err", err)
+		}
+		g.Close()
+		return false
+	}
+
+	normalized, err := g.names.check(name)
+	if err != nil {
+		g.Reject(fmt.Sprintf("Invalid name %s: %v", name, err))
+		return false
+	}
+	if g.accounts.Registered(normalized) {
+		var ok bool
+		if normalized, ok = lineLogin(g, normalized); !ok {
+			return false
+		}
+	}
+
+	g.Name = normalized
+	g.Conn.SetReadDeadline(time.Time{})
+	return true
+}
+
+func lineLogin(g *Guest, name string) (string, bool) {
+	if err := g.send(fmt.Sprintf("%s is registered, what is the password?", name)); err != nil {
+		g.Close()
+		return "", false
+	}
+	password, err := g.reader.ReadLine()
+	if err != nil {
+		g.logger.Info("Guest left at login", "err", err)
+		g.Close()
+		return "", false
+	}
+	joined, ok := g.login(name, password)
+	if ok && joined != name {
+		err = g.send(fmt.Sprintf("* Wrong password for %s, joining as %s", name, joined))
+	}
+	return joined, ok && err == nil
+}
+
+func (lineProtocol) newCodec(defaultRoom string) codec {
+	return lineCodec{defaultRoom: defaultRoom}
+}
+
+type lineCodec struct {
+	defaultRoom string
+}
+
+// handle treats lines starting with "/" as commands and everything else
+// as a message for the user's current room. A leading "//" escapes the
+// slash.
+func (lineCodec) handle(h *Hub, user *User, text string) {
+	switch {
+	case strings.HasPrefix(text, "//"):
+		text = text[1:]
+	case strings.HasPrefix(text, "/"):
+		h.handleCommand(user, text)
+		return
+	}
+	room, ok := h.currentRoom(user)
+	if !ok {
+		return
+	}
+	room.handleMessage(Message{From: user.Name, Text: text})
+}
+
+// format prefixes lines from rooms other than the default one with the
+// room name, so users in several rooms can tell them apart.
+func (c lineCodec) format(user *User, m Message) []string {
+	if (m.Kind == JoinMessage || m.Kind == PartMessage) && m.Subject == user.Name && !m.History {
+		// Commands already tell the user about its own joins and parts.
+		return nil
+	}
+	text := m.String()
+	if m.History {
+		text = fmt.Sprintf("[%s] %s", m.Time.Format(time.TimeOnly), text)
+	}
+	if m.Room != "" && m.Room != c.defaultRoom {
+		text = "#" + m.Room + " " + text
+	}
+	return []string{text}
+}
+
+func (lineCodec) reject(reason string) string {
+	return reason
+}
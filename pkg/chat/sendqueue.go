@@ -0,0 +1,113 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const DefaultSendQueueSize = 256
+
+// ErrTooSlow is the reason a user is disconnected under SlowDisconnect.
+var ErrTooSlow = errors.New("too slow")
+
+// SlowPolicy decides what happens when a user's send queue is full.
+type SlowPolicy int
+
+const (
+	// SlowDisconnect drops the user.
+	SlowDisconnect SlowPolicy = iota
+	// SlowDropOldest discards the oldest queued line to make room.
+	SlowDropOldest
+	// SlowDropNewest discards the line being sent.
+	SlowDropNewest
+)
+
+func (p SlowPolicy) String() string {
+	return [...]string{"disconnect", "drop-oldest", "drop-newest"}[p]
+}
+
+func ParseSlowPolicy(s string) (SlowPolicy, error) {
+	switch strings.ToLower(s) {
+	case "disconnect":
+		return SlowDisconnect, nil
+	case "drop-oldest":
+		return SlowDropOldest, nil
+	case "drop-newest":
+		return SlowDropNewest, nil
+	}
+	return 0, fmt.Errorf("unknown slow consumer policy %q", s)
+}
+
+func (p SlowPolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *SlowPolicy) UnmarshalText(b []byte) error {
+	parsed, err := ParseSlowPolicy(string(b))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// sendQueue holds lines waiting to be written to a user, so that senders
+// never block on a client that stopped reading.
+type sendQueue struct {
+	mu     sync.Mutex
+	items  []string
+	max    int
+	policy SlowPolicy
+	// ready has room for one wake-up so push never blocks.
+	ready chan struct{}
+}
+
+func newSendQueue(max int, policy SlowPolicy) *sendQueue {
+	if max <= 0 {
+		max = DefaultSendQueueSize
+	}
+	return &sendQueue{
+		max:    max,
+		policy: policy,
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// push queues text and reports how many lines were dropped to stay under
+// the limit. It returns ErrTooSlow instead when the policy is to
+// disconnect.
+func (q *sendQueue) push(text string) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	dropped := 0
+	if len(q.items) >= q.max {
+		switch q.policy {
+		case SlowDropNewest:
+			return 1, nil
+		case SlowDropOldest:
+			q.items[0] = ""
+			q.items = q.items[1:]
+			dropped = 1
+		default:
+			return 0, ErrTooSlow
+		}
+	}
+	q.items = append(q.items, text)
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return dropped, nil
+}
+
+func (q *sendQueue) drain() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := q.items
+	q.items = nil
+	return items
+}
@@ -0,0 +1,36 @@
+package chat
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// TestSendQueuePolicies tests what each policy does with a full queue
+func TestSendQueuePolicies(t *testing.T) {
+	cases := []struct {
+		policy  SlowPolicy
+		want    []string
+		dropped int
+		err     error
+	}{
+		{SlowDropOldest, []string{"b", "c"}, 1, nil},
+		{SlowDropNewest, []string{"a", "b"}, 1, nil},
+		{SlowDisconnect, []string{"a", "b"}, 0, ErrTooSlow},
+	}
+	for _, c := range cases {
+		q := newSendQueue(2, c.policy)
+		q.push("a")
+		q.push("b")
+		dropped, err := q.push("c")
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: Expected error %v, got %v", c.policy, c.err, err)
+		}
+		if dropped != c.dropped {
+			t.Errorf("%s: Expected %d dropped, got %d", c.policy, c.dropped, dropped)
+		}
+		if got := q.drain(); !slices.Equal(got, c.want) {
+			t.Errorf("%s: Expected %v, got %v", c.policy, c.want, got)
+		}
+	}
+}
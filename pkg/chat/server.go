@@ -2,58 +2,330 @@ package chat
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
 	"net"
-	"os"
-	"os/signal"
-	"syscall"
+	"net/http"
 	"time"
+
+	"github.com/insomnes/protohackers/pkg/config"
+	"github.com/insomnes/protohackers/pkg/limiter"
+	"github.com/insomnes/protohackers/pkg/lineio"
+	"github.com/insomnes/protohackers/pkg/metrics"
+)
+
+const (
+	EventChannelSize = 16
+	// DefaultMaxMessageSize leaves room for 1000 characters of any script.
+	DefaultMaxMessageSize = 4096
+	DefaultHistorySize    = 100
+)
+
+var (
+	chatMetrics     = metrics.ForHandler("chat")
+	droppedMessages = metrics.Default.Counter(
+		"protohackers_chat_dropped_messages_total",
+		"Chat lines dropped because a user did not read them in time.",
+		"policy",
+	)
+	slowDisconnects = metrics.Default.Counter(
+		"protohackers_chat_slow_disconnects_total",
+		"Chat users disconnected for not reading in time.",
+	).With()
+	floodEvents = metrics.Default.Counter(
+		"protohackers_chat_flood_total",
+		"Chat lines dropped and users muted or disconnected by flood control.",
+		"action",
+	)
 )
 
-const EventChannelSize = 16
+type Config struct {
+	Address string         `json:"-"`
+	Limits  limiter.Config `json:"limits"`
+	// MaxMessageSize is the longest line in bytes a client may send.
+	MaxMessageSize int           `json:"max_message_size"`
+	OversizePolicy lineio.Policy `json:"oversize_policy"`
+	// DefaultRoom is where new users land.
+	DefaultRoom string `json:"default_room"`
+	// HistorySize is how many recent messages each room keeps.
+	HistorySize int `json:"history_size"`
+	// HistoryReplay is how many of them a user gets on joining a room.
+	// Zero keeps the plain protohackers behaviour.
+	HistoryReplay int `json:"history_replay"`
+	// HistoryMaxAge leaves older messages out of replays, zero keeps all.
+	HistoryMaxAge config.Duration `json:"history_max_age"`
+	// Transcript is the file room traffic is appended to and history is
+	// reloaded from on start. Empty disables it.
+	Transcript string `json:"transcript"`
+	// TranscriptMaxSize is the size in bytes at which the file is rotated.
+	TranscriptMaxSize int64 `json:"transcript_max_size"`
+	// TranscriptKeep is how many rotated files are kept.
+	TranscriptKeep int `json:"transcript_keep"`
+	// SendQueueSize is how many lines may wait for a user before
+	// SlowPolicy kicks in.
+	SendQueueSize int        `json:"send_queue_size"`
+	SlowPolicy    SlowPolicy `json:"slow_policy"`
+	// Operators are registered names that get operator rights on joining
+	// with their password. Without Accounts only /oper grants them.
+	Operators []string `json:"operators"`
+	// OperPassword grants operator rights through /oper, empty disables it.
+	OperPassword string `json:"oper_password"`
+	// BanList is the file bans are kept in, empty keeps them in memory.
+	BanList string      `json:"ban_list"`
+	Flood   FloodConfig `json:"flood"`
+	// IdleAfter flags users without messages or commands for that long as
+	// idle in /who and tells their rooms, 0 never does.
+	IdleAfter config.Duration `json:"idle_after"`
+	// IdleTimeout disconnects users that sent nothing at all for that
+	// long, 0 never does.
+	IdleTimeout config.Duration `json:"idle_timeout"`
+	// WebSocket is the address of an optional WebSocket listener that
+	// joins browsers to the same rooms, one text message per line.
+	WebSocket string `json:"websocket"`
+	// IRC is the address of an optional listener for IRC clients.
+	IRC string `json:"irc"`
+	// Admin is the address of an optional HTTP listener for the JSON admin
+	// API. AdminToken is required with it and must be sent as a bearer
+	// token.
+	Admin      string `json:"admin"`
+	AdminToken string `json:"admin_token"`
+	// Accounts is the file registered names are kept in, with hashes of
+	// their passwords. Empty leaves every name free to take and disables
+	// /register.
+	Accounts string `json:"accounts"`
+	// LoginPolicy is what happens to guests taking a registered name
+	// without its password.
+	LoginPolicy LoginPolicy      `json:"login_policy"`
+	Names       NamePolicy       `json:"names"`
+	Federation  FederationConfig `json:"federation"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxMessageSize: DefaultMaxMessageSize,
+		OversizePolicy: lineio.Reject,
+		DefaultRoom:    DefaultRoom,
+		HistorySize:    DefaultHistorySize,
+
+		TranscriptMaxSize: DefaultTranscriptMaxSize,
+		TranscriptKeep:    DefaultTranscriptKeep,
+
+		SendQueueSize: DefaultSendQueueSize,
+		SlowPolicy:    SlowDisconnect,
+
+		Flood: FloodConfig{
+			MaxViolations: DefaultFloodMaxViolations,
+			Action:        FloodMute,
+			MuteFor:       config.Duration{Duration: DefaultFloodMuteFor},
+		},
+
+		IdleAfter: config.Duration{Duration: DefaultIdleAfter},
+		Names:     DefaultNamePolicy(),
+	}
+}
 
 type ChatServer struct {
-	Address string
+	Config
+	bots []Bot
 }
 
-func NewChatServer(address string) ChatServer {
+// AddBot registers bots to join when the server starts.
+func (cs *ChatServer) AddBot(bots ...Bot) {
+	cs.bots = append(cs.bots, bots...)
+}
+
+func NewChatServer(cfg Config) ChatServer {
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = DefaultMaxMessageSize
+	}
 	return ChatServer{
-		Address: address,
+		Config: cfg,
 	}
 }
 
-func (cs *ChatServer) Run() {
+func (cs *ChatServer) Run(ctx context.Context) error {
+	if err := cs.Names.validate(); err != nil {
+		return fmt.Errorf("invalid name policy: %w", err)
+	}
+	if cs.Admin != "" && cs.AdminToken == "" {
+		return fmt.Errorf("admin api needs a token")
+	}
+	transcript, err := cs.openTranscript()
+	if err != nil {
+		return err
+	}
+	defer transcript.Close()
+
+	bans, err := LoadBanList(cs.BanList)
+	if err != nil {
+		return err
+	}
+
+	var accounts *AccountStore
+	if cs.Accounts != "" {
+		accounts, err = LoadAccounts(cs.Accounts)
+		if err != nil {
+			return err
+		}
+	} else if len(cs.Operators) > 0 {
+		slog.Warn("Operators need accounts to log in, only /oper grants operator rights", "operators", cs.Operators)
+	}
+
+	hub := NewHub(cs.Config, transcript, bans, accounts)
+	if transcript != nil {
+		messages, err := LoadTranscript(cs.Transcript, cs.TranscriptKeep, cs.HistorySize)
+		if err != nil {
+			return err
+		}
+		hub.Restore(messages)
+		slog.Info("Transcript loaded", "path", cs.Transcript, "messages", len(messages))
+	}
+
 	ln, err := net.Listen("tcp", cs.Address)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to listen: %w", err)
 	}
+	defer ln.Close()
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	var wsLn net.Listener
+	if cs.WebSocket != "" {
+		wsLn, err = net.Listen("tcp", cs.WebSocket)
+		if err != nil {
+			return fmt.Errorf("failed to listen for websocket: %w", err)
+		}
+		defer wsLn.Close()
+	}
 
-	chatRoom := NewChatRoom()
-	butler := NewButler(&chatRoom)
+	var ircLn net.Listener
+	if cs.IRC != "" {
+		ircLn, err = net.Listen("tcp", cs.IRC)
+		if err != nil {
+			return fmt.Errorf("failed to listen for irc: %w", err)
+		}
+		defer ircLn.Close()
+		slog.Info("Serving chat over IRC", "addr", ircLn.Addr().String())
+	}
+
+	fed := cs.Federation
+	var fedLn net.Listener
+	if fed.Name != "" || fed.Listen != "" || len(fed.Peers) > 0 {
+		if err := fed.validate(); err != nil {
+			return err
+		}
+	}
+	if fed.Listen != "" {
+		fedLn, err = net.Listen("tcp", fed.Listen)
+		if err != nil {
+			return fmt.Errorf("failed to listen for federation: %w", err)
+		}
+		defer fedLn.Close()
+		slog.Info("Accepting federation links", "addr", fedLn.Addr().String(), "name", fed.Name)
+	}
+
+	var adminLn net.Listener
+	if cs.Admin != "" {
+		adminLn, err = net.Listen("tcp", cs.Admin)
+		if err != nil {
+			return fmt.Errorf("failed to listen for admin api: %w", err)
+		}
+		defer adminLn.Close()
+	}
+
+	butler := NewButler(hub, bans, accounts, cs.Config)
+	connLimiter := limiter.New(cs.Limits)
+	admit := func(conn net.Conn, proto protocol) {
+		limited, err := connLimiter.Accept(conn)
+		if err != nil {
+			chatMetrics.Refused(limiter.Reason(err))
+			refuse(conn, err, proto.newCodec(hub.defaultRoom))
+			return
+		}
+		slog.Debug("Connection accepted", "remote", limited.RemoteAddr().String())
+		chatMetrics.Accepted.Inc()
+		butler.AddConnection(limited, proto)
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	go chatRoom.Run(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go hub.Run(ctx)
 	go butler.Run(ctx)
+	for _, bot := range cs.bots {
+		if err := hub.AddBot(ctx, bot); err != nil {
+			return fmt.Errorf("failed to add bot: %w", err)
+		}
+	}
 
+	listenErr := make(chan error, 5)
 	go func() {
-		defer ln.Close()
+		listenErr <- accept(ln, lineProtocol{}, admit)
+	}()
+	if ircLn != nil {
+		go func() {
+			listenErr <- accept(ircLn, ircProtocol{}, admit)
+		}()
+	}
+	if fedLn != nil {
+		go func() {
+			listenErr <- hub.acceptLinks(ctx, fedLn)
+		}()
+	}
+	for _, peer := range fed.Peers {
+		go hub.dialPeer(ctx, peer)
+	}
+	if wsLn != nil {
+		wsServer := &http.Server{}
+		defer wsServer.Close()
+		go func() {
+			if err := cs.serveWebSocket(wsServer, wsLn, admit); err != nil {
+				listenErr <- err
+			}
+		}()
+	}
 
-		for {
-			conn, err := ln.Accept()
-			if err != nil {
-				log.Println("Error accepting connection:", err)
-				return
+	if adminLn != nil {
+		adminServer := &http.Server{
+			Handler:           newAdminHandler(hub, bans, cs.AdminToken),
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		defer adminServer.Close()
+		go func() {
+			if err := serveAdmin(adminServer, adminLn); err != nil {
+				listenErr <- err
 			}
-			log.Printf("Connection from %s\n", conn.RemoteAddr())
-			butler.AddConnection(conn)
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+		ln.Close()
+		<-time.After(1 * time.Second)
+		return nil
+	case err := <-listenErr:
+		cancel()
+		return err
+	}
+}
+
+func accept(ln net.Listener, proto protocol, admit func(net.Conn, protocol)) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return fmt.Errorf("failed to accept: %w", err)
 		}
-	}()
+		admit(conn, proto)
+	}
+}
+
+// openTranscript returns nil when no transcript is configured.
+func (cs *ChatServer) openTranscript() (*Transcript, error) {
+	if cs.Transcript == "" {
+		return nil, nil
+	}
+	return OpenTranscript(cs.Transcript, cs.TranscriptMaxSize, cs.TranscriptKeep)
+}
 
-	sig := <-sigChan
-	log.Printf("Signal received: %v\n", sig)
-	cancel()
-	<-time.After(1 * time.Second)
+func refuse(conn net.Conn, reason error, c codec) {
+	slog.Warn("Refusing connection", "remote", conn.RemoteAddr().String(), "reason", reason)
+	text := c.reject(fmt.Sprintf("* Server is busy (%v), try again later", reason))
+	limiter.Refuse(conn, []byte(text+"\n"))
 }
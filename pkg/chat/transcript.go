@@ -0,0 +1,215 @@
+package chat
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultTranscriptMaxSize = 10 << 20
+	DefaultTranscriptKeep    = 5
+)
+
+type transcriptEntry struct {
+	Time time.Time   `json:"time"`
+	Room string      `json:"room"`
+	Kind MessageKind `json:"kind"`
+	From string      `json:"from,omitempty"`
+	Text string      `json:"text"`
+}
+
+// Transcript is an append-only log of room traffic, one JSON object per
+// line. Once the file grows past maxSize it is rotated to path.1, path.2
+// and so on, keeping at most keep old files. A nil Transcript discards
+// everything.
+type Transcript struct {
+	path    string
+	maxSize int64
+	keep    int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func OpenTranscript(path string, maxSize int64, keep int) (*Transcript, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultTranscriptMaxSize
+	}
+	t := &Transcript{path: path, maxSize: maxSize, keep: keep}
+	if err := t.open(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Transcript) open() error {
+	f, err := os.OpenFile(t.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open transcript: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat transcript: %w", err)
+	}
+	t.f = f
+	t.size = info.Size()
+	return nil
+}
+
+func (t *Transcript) Append(m Message) error {
+	if t == nil {
+		return nil
+	}
+	line, err := json.Marshal(transcriptEntry{
+		Time: m.Time,
+		Room: m.Room,
+		Kind: m.Kind,
+		From: m.From,
+		Text: m.Text,
+	})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.f == nil {
+		return fs.ErrClosed
+	}
+	if t.size > 0 && t.size+int64(len(line)) > t.maxSize {
+		if err := t.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := t.f.Write(line)
+	t.size += int64(n)
+	return err
+}
+
+func (t *Transcript) rotate() error {
+	t.f.Close()
+	t.f = nil
+	if t.keep <= 0 {
+		if err := os.Remove(t.path); err != nil {
+			return fmt.Errorf("failed to rotate transcript: %w", err)
+		}
+		return t.open()
+	}
+	os.Remove(rotatedName(t.path, t.keep))
+	for i := t.keep - 1; i >= 1; i-- {
+		err := os.Rename(rotatedName(t.path, i), rotatedName(t.path, i+1))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to rotate transcript: %w", err)
+		}
+	}
+	if err := os.Rename(t.path, rotatedName(t.path, 1)); err != nil {
+		return fmt.Errorf("failed to rotate transcript: %w", err)
+	}
+	return t.open()
+}
+
+func (t *Transcript) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.f == nil {
+		return nil
+	}
+	err := t.f.Close()
+	t.f = nil
+	return err
+}
+
+func rotatedName(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// LoadTranscript reloads the last perRoom messages of each room, oldest
+// first. Files are read newest first and reading stops once every room
+// seen so far is full, so rooms found only in older files are left out.
+// Missing files are skipped and so are lines that do not parse.
+func LoadTranscript(path string, keep, perRoom int) ([]Message, error) {
+	if perRoom <= 0 {
+		return nil, nil
+	}
+	rooms := make(map[string][]Message)
+	for i := 0; i <= keep; i++ {
+		name := path
+		if i > 0 {
+			name = rotatedName(path, i)
+		}
+		loaded, err := loadTranscriptFile(name, perRoom)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for room, hist := range loaded {
+			newer := rooms[room]
+			rooms[room] = append(hist.last(perRoom-len(newer), time.Time{}), newer...)
+		}
+		full := true
+		for _, messages := range rooms {
+			full = full && len(messages) == perRoom
+		}
+		if full {
+			break
+		}
+	}
+
+	var messages []Message
+	for _, loaded := range rooms {
+		messages = append(messages, loaded...)
+	}
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].Time.Before(messages[j].Time) })
+	return messages, nil
+}
+
+func loadTranscriptFile(name string, perRoom int) (map[string]*history, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rooms := make(map[string]*history)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry transcriptEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			slog.Warn("Skipping bad transcript line", "file", name, "err", err)
+			continue
+		}
+		hist, ok := rooms[entry.Room]
+		if !ok {
+			hist = newHistory(perRoom, 0)
+			rooms[entry.Room] = hist
+		}
+		hist.add(Message{
+			Kind: entry.Kind,
+			Room: entry.Room,
+			From: entry.From,
+			Text: entry.Text,
+			Time: entry.Time,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read transcript %s: %w", name, err)
+	}
+	return rooms, nil
+}
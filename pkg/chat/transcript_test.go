@@ -0,0 +1,82 @@
+package chat
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestTranscriptRotation tests that rotated files are reloaded in order and old ones dropped
+func TestTranscriptRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.log")
+	tr, err := OpenTranscript(path, 150, 2)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	texts := []string{"one", "two", "three", "four", "five", "six"}
+	for _, text := range texts {
+		err := tr.Append(Message{Room: "lobby", From: "alice", Text: text, Time: time.Now()})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	tr.Close()
+
+	if _, err := os.Stat(rotatedName(path, 3)); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed, got %v", rotatedName(path, 3), err)
+	}
+
+	messages, err := LoadTranscript(path, 2, DefaultHistorySize)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(messages) == 0 || len(messages) >= len(texts) {
+		t.Fatalf("Expected some but not all messages, got %d", len(messages))
+	}
+	offset := len(texts) - len(messages)
+	for i, m := range messages {
+		if m.Text != texts[offset+i] || m.Room != "lobby" || m.From != "alice" {
+			t.Errorf("Expected %s from alice in lobby, got %+v", texts[offset+i], m)
+		}
+	}
+}
+
+// TestLoadTranscriptPerRoom tests that only the last messages of each room
+// are kept and older files are not read once every room is full
+func TestLoadTranscriptPerRoom(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.log")
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	write := func(file int, rooms ...string) {
+		var lines []byte
+		for i, room := range rooms {
+			at := start.Add(time.Duration(-file)*time.Hour + time.Duration(i)*time.Second)
+			lines = fmt.Appendf(lines, `{"time":%q,"room":%q,"text":"%d.%d"}`+"\n", at.Format(time.RFC3339), room, file, i)
+		}
+		name := path
+		if file > 0 {
+			name = rotatedName(path, file)
+		}
+		if err := os.WriteFile(name, lines, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(2, "old")
+	write(1, "lobby", "dev", "lobby")
+	write(0, "lobby", "lobby", "dev", "lobby")
+
+	messages, err := LoadTranscript(path, 2, 2)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var got []string
+	for _, m := range messages {
+		got = append(got, m.Room+" "+m.Text)
+	}
+	want := []string{"dev 1.1", "lobby 0.1", "dev 0.2", "lobby 0.3"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
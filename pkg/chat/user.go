@@ -1,92 +1,251 @@
 package chat
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"sync"
+	"time"
+
+	"github.com/insomnes/protohackers/pkg/lineio"
 )
 
+// userWriteTimeout bounds a single write to a user, so a client that
+// stops reading cannot hold its send loop forever.
+const userWriteTimeout = 10 * time.Second
+
+// UserError carries the user itself rather than its name, which may
+// change under /nick.
 type UserError struct {
+	User *User
 	Addr string
-	Name string
 	Err  error
 }
 
 func (ue UserError) Error() string {
-	return fmt.Sprintf("user [%s] (%s) error: %v", ue.Name, ue.Addr, ue.Err)
+	return fmt.Sprintf("user %s (%s) error: %v", ue.User.ID, ue.Addr, ue.Err)
+}
+
+// Line is a raw line read from a user. The room decides whether it is a
+// command or a message.
+type Line struct {
+	User *User
+	Text string
+	// Notice, when set, is a system message for the user itself, raised
+	// by its read loop.
+	Notice string
 }
 
 type User struct {
+	ID      string
 	Address string
 	Name    string
 
 	rxChan chan Message
-	txChan chan string
+	codec  codec
+	queue  *sendQueue
+	flood  *floodGuard
+
+	quit     chan struct{}
+	quitOnce sync.Once
+	// slow is closed when the send queue overflows under SlowDisconnect.
+	slow     chan struct{}
+	slowOnce sync.Once
+
+	// rooms, room (the current one), oper and the mute belong to the Hub
+	// event loop.
+	rooms map[string]*ChatRoom
+	room  *ChatRoom
+	oper  bool
+	muted bool
+	// mutedUntil is zero for a mute that lasts until the user leaves.
+	mutedUntil time.Time
+	// sent and received count messages from and to the user.
+	sent     int
+	received int
+	away     bool
+	awayText string
+	// lastSeen is the last line of any kind, lastActive the last message
+	// or command. Users are idle idleAfter past lastActive, and idle is
+	// whether their rooms were told so.
+	lastSeen   time.Time
+	lastActive time.Time
+	idleAfter  time.Duration
+	idle       bool
+
+	connectedAt time.Time
+	// bot users have no connection, see BotClient.
+	bot bool
+	// remote is set for users of federated servers, which have no
+	// connection either.
+	remote *remoteUser
+	// account is the registered name the user proved to own, if any.
+	account string
 
-	conn net.Conn
+	conn   net.Conn
+	reader *lineio.Reader
+	logger *slog.Logger
 }
 
-func NewUser(conn net.Conn, name string) User {
-	return User{
-		Address: conn.RemoteAddr().String(),
-		Name:    name,
+func NewUser(guest *Guest, cfg Config) *User {
+	now := time.Now()
+	return &User{
+		ID:      guest.ID,
+		Address: guest.Conn.RemoteAddr().String(),
+		Name:    guest.Name,
 		rxChan:  make(chan Message, EventChannelSize),
-		txChan:  make(chan string, EventChannelSize),
-		conn:    conn,
+		codec:   guest.codec,
+		queue:   newSendQueue(cfg.SendQueueSize, cfg.SlowPolicy),
+		flood:   newFloodGuard(cfg.Flood, time.Now()),
+		quit:    make(chan struct{}),
+		slow:    make(chan struct{}),
+		rooms:   make(map[string]*ChatRoom),
+		conn:    guest.Conn,
+		reader:  guest.reader,
+		logger:  guest.logger,
+		account: guest.account,
+
+		connectedAt: now,
+		lastSeen:    now,
+		lastActive:  now,
+		idleAfter:   cfg.IdleAfter.Duration,
 	}
 }
 
-func (u *User) Run(ctx context.Context, messages chan<- Message, fail chan<- UserError) {
+func (u *User) Run(ctx context.Context, lines chan<- Line, fail chan<- UserError) {
 	userCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	defer u.conn.Close()
 
 	userFail := make(chan UserError, EventChannelSize)
-	go u.runRX(userFail, messages)
+	go u.runRX(userFail, lines)
 	go u.runTX(userCtx, userFail)
 
 	select {
 	case <-ctx.Done():
-		log.Printf("External stop for [%s] (%s)\n", u.Name, u.Address)
+		u.logger.Info("External stop for user")
 		return
 	case err := <-userFail:
-		log.Println("Stopping", err.Error())
+		u.logger.Info("Stopping user", "err", err.Err)
 		fail <- err
 		return
+	case <-u.slow:
+		u.logger.Warn("Disconnecting slow user", "queued", u.queue.max)
+		slowDisconnects.Inc()
+		fail <- u.NewError(ErrTooSlow)
+		return
+	}
+}
+
+// Deliver renders the message in the user's protocol and queues it. It is
+// called from the Hub event loop.
+func (u *User) Deliver(m Message) {
+	u.received++
+	for _, text := range u.codec.format(u, m) {
+		u.Send(text)
+		chatMetrics.Written(len(text) + 1)
 	}
 }
 
+// Send queues text for the user without blocking. What happens when the
+// queue is full is up to the server's SlowPolicy.
 func (u *User) Send(text string) {
-	u.txChan <- text
+	dropped, err := u.queue.push(text)
+	if errors.Is(err, ErrTooSlow) {
+		u.slowOnce.Do(func() { close(u.slow) })
+		return
+	}
+	if dropped > 0 {
+		u.logger.Debug("Dropped message for slow user", "policy", u.queue.policy.String())
+		droppedMessages.With(u.queue.policy.String()).Add(dropped)
+	}
+}
+
+// Quit closes the connection once everything already sent to the user
+// has been written.
+func (u *User) Quit() {
+	u.quitOnce.Do(func() { close(u.quit) })
 }
 
-func (u *User) runRX(fail chan<- UserError, messages chan<- Message) {
-	reader := bufio.NewReader(u.conn)
+func (u *User) runRX(fail chan<- UserError, lines chan<- Line) {
 	for {
-		text, err := reader.ReadString('\n')
-		if err != nil {
-			log.Printf("User can not read from %s: %v\n", u.Address, err)
+		text, err := u.reader.ReadLine()
+		if errors.Is(err, lineio.ErrLineTooLong) {
+			chatMetrics.Oversized.Inc()
+			if !u.handleTooLong(lines) {
+				fail <- u.NewError(err)
+				return
+			}
+			if u.reader.Policy() != lineio.Truncate {
+				continue
+			}
+		} else if err != nil {
+			u.logger.Info("User can not read", "err", err)
 			fail <- u.NewError(err)
 			return
 		}
-		messages <- Message{
-			From: u.Name,
-			Text: text[:len(text)-1],
+		switch verdict := u.flood.check(time.Now()); verdict {
+		case floodAllow:
+			lines <- Line{User: u, Text: text}
+		case floodDisconnect:
+			u.logger.Warn("Disconnecting flooding user")
+			floodEvents.With("disconnected").Inc()
+			fail <- u.NewError(ErrFlooding)
+			return
+		default:
+			u.handleFlood(lines, verdict)
 		}
 	}
 }
 
+// handleTooLong tells the user what happened to an oversized line and
+// reports whether the user may stay connected.
+func (u *User) handleTooLong(lines chan<- Line) bool {
+	max := u.reader.Max()
+	u.logger.Warn("Message too long", "max", max, "policy", u.reader.Policy().String())
+	switch u.reader.Policy() {
+	case lineio.Reject:
+		u.notify(lines, "Message too long (max %d bytes), not sent", max)
+		return true
+	case lineio.Truncate:
+		u.notify(lines, "Message too long, truncated to %d bytes", max)
+		return true
+	default:
+		return false
+	}
+}
+
+func (u *User) handleFlood(lines chan<- Line, verdict floodVerdict) {
+	floodEvents.With("dropped").Inc()
+	switch verdict {
+	case floodWarn:
+		u.logger.Warn("User is flooding, dropping lines")
+		u.notify(lines, "Slow down! You are sending too fast, lines are being dropped")
+	case floodMuted:
+		u.logger.Warn("Muting flooding user", "for", u.flood.cfg.MuteFor.Duration)
+		floodEvents.With("muted").Inc()
+		u.notify(lines, "You are muted for %s for flooding", u.flood.cfg.MuteFor.Duration)
+	}
+}
+
+// notify has the Hub send the user a notice in its own protocol.
+func (u *User) notify(lines chan<- Line, format string, args ...any) {
+	lines <- Line{User: u, Notice: fmt.Sprintf(format, args...)}
+}
+
 func (u *User) runTX(ctx context.Context, fail chan<- UserError) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case text := <-u.txChan:
-			_, err := u.conn.Write([]byte(text + "\n"))
-			if err != nil {
-				log.Printf("Error writing to %s: %v\n", u.Address, err)
+		case <-u.quit:
+			u.flush()
+			u.conn.Close()
+			return
+		case <-u.queue.ready:
+			if err := u.flush(); err != nil {
 				fail <- u.NewError(err)
 				return
 			}
@@ -94,10 +253,69 @@ func (u *User) runTX(ctx context.Context, fail chan<- UserError) {
 	}
 }
 
+func (u *User) flush() error {
+	for _, text := range u.queue.drain() {
+		if err := u.write(text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write sends one line. A user that does not read it within
+// userWriteTimeout is too slow to keep.
+func (u *User) write(text string) error {
+	u.conn.SetWriteDeadline(time.Now().Add(userWriteTimeout))
+	_, err := u.conn.Write([]byte(text + "\n"))
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		u.logger.Warn("Disconnecting slow user", "timeout", userWriteTimeout)
+		slowDisconnects.Inc()
+		return ErrTooSlow
+	}
+	if err != nil {
+		u.logger.Warn("Error writing to user", "err", err)
+	}
+	return err
+}
+
+func (u *User) isMuted(now time.Time) bool {
+	return u.muted && (u.mutedUntil.IsZero() || now.Before(u.mutedUntil))
+}
+
+func (u *User) isIdle(now time.Time) bool {
+	return u.idleAfter > 0 && now.Sub(u.lastActive) >= u.idleAfter
+}
+
+// presence describes an away or idle user for /who, empty otherwise.
+func (u *User) presence(now time.Time) string {
+	switch {
+	case u.bot:
+		return "bot"
+	case u.remote != nil:
+		return "on " + u.remote.origin
+	case u.away && u.awayText != "":
+		return "away: " + u.awayText
+	case u.away:
+		return "away"
+	case u.isIdle(now):
+		return "idle"
+	}
+	return ""
+}
+
+// origin is the server the user is connected to, empty for this one.
+func (u *User) origin() string {
+	if u.remote == nil {
+		return ""
+	}
+	return u.remote.origin
+}
+
 func (u *User) NewError(err error) UserError {
 	return UserError{
+		User: u,
 		Addr: u.Address,
-		Name: u.Name,
 		Err:  err,
 	}
 }
@@ -0,0 +1,98 @@
+package chat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/insomnes/protohackers/pkg/websocket"
+)
+
+var errNotText = errors.New("websocket: only text messages are supported")
+
+var newlineReplacer = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ")
+
+// wsConn presents a WebSocket as a line-oriented net.Conn, so guests and
+// users behave exactly as over TCP: every text message is one line in and
+// every line written goes out as one text message.
+type wsConn struct {
+	net.Conn
+	ws *websocket.Conn
+
+	rbuf []byte
+
+	wmu  sync.Mutex
+	wbuf []byte
+}
+
+func newWSConn(ws *websocket.Conn) *wsConn {
+	return &wsConn{Conn: ws.NetConn(), ws: ws}
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for len(c.rbuf) == 0 {
+		opcode, msg, err := c.ws.ReadMessage()
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		if opcode != websocket.OpText {
+			c.ws.Close(websocket.CloseUnsupportedData, "text only")
+			return 0, errNotText
+		}
+		c.rbuf = append([]byte(newlineReplacer.Replace(string(msg))), '\n')
+	}
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.wbuf = append(c.wbuf, p...)
+	for {
+		i := bytes.IndexByte(c.wbuf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := c.wbuf[:i]
+		c.wbuf = c.wbuf[i+1:]
+		if err := c.ws.WriteMessage(websocket.OpText, line); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (c *wsConn) Close() error {
+	return c.ws.Close(websocket.CloseNormal, "")
+}
+
+// serveWebSocket upgrades every request on ln and hands the resulting
+// connections to admit. It returns once srv is closed.
+func (cs *ChatServer) serveWebSocket(srv *http.Server, ln net.Listener, admit func(net.Conn, protocol)) error {
+	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := websocket.Upgrade(w, r)
+		if err != nil {
+			slog.Debug("WebSocket upgrade failed", "remote", r.RemoteAddr, "err", err)
+			return
+		}
+		ws.MaxMessageSize = max(websocket.DefaultMaxMessageSize, cs.MaxMessageSize+1)
+		admit(newWSConn(ws), lineProtocol{})
+	})
+	slog.Info("Serving chat over WebSocket", "addr", ln.Addr().String())
+	err := srv.Serve(ln)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return fmt.Errorf("websocket listener: %w", err)
+}
@@ -0,0 +1,160 @@
+package chatbot
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/insomnes/protohackers/pkg/chat"
+	"github.com/insomnes/protohackers/pkg/lineio"
+)
+
+const (
+	// deployPollInterval is how often a regular feed file is checked for
+	// new lines.
+	deployPollInterval = time.Second
+	// maxDeployLine bounds a FIFO line; longer ones are skipped.
+	maxDeployLine = chat.DefaultMaxMessageSize
+)
+
+// DeployBot announces every line written to a feed in its rooms. The feed
+// is either a FIFO or a regular file that is followed like tail -f, so
+// only lines appended after start are announced.
+type DeployBot struct {
+	name  string
+	rooms []string
+	feed  string
+}
+
+func NewDeployBot(name string, rooms []string, feed string) *DeployBot {
+	return &DeployBot{name: name, rooms: rooms, feed: feed}
+}
+
+func (b *DeployBot) Name() string {
+	return b.name
+}
+
+func (b *DeployBot) Rooms() []string {
+	return b.rooms
+}
+
+func (b *DeployBot) Handle(*chat.BotClient, chat.Message) {}
+
+func (b *DeployBot) Run(ctx context.Context, c *chat.BotClient) {
+	err := b.follow(ctx, c.Logger(), func(line string) {
+		c.Say("", "Deploy: "+line)
+	})
+	if err != nil && ctx.Err() == nil {
+		c.Logger().Error("Deploy feed failed", "feed", b.feed, "err", err)
+	}
+}
+
+// follow calls announce with every new non-empty line of the feed until
+// ctx is done.
+func (b *DeployBot) follow(ctx context.Context, logger *slog.Logger, announce func(string)) error {
+	info, err := os.Stat(b.feed)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeNamedPipe != 0 {
+		return followFIFO(ctx, b.feed, logger, announce)
+	}
+	f, offset, err := openAtEnd(b.feed)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return followFile(ctx, f, offset, announce)
+}
+
+// followFIFO opens the FIFO for writing too, so opening does not wait for
+// a writer and the read does not end when a writer closes.
+func followFIFO(ctx context.Context, path string, logger *slog.Logger, announce func(string)) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { f.Close() })
+	defer stop()
+	defer f.Close()
+
+	reader := lineio.NewReader(f, maxDeployLine, lineio.Reject)
+	for {
+		line, err := reader.ReadLine()
+		switch {
+		case errors.Is(err, lineio.ErrLineTooLong):
+			logger.Warn("Skipping long deploy line", "feed", path, "max", maxDeployLine)
+			continue
+		case err != nil && !errors.Is(err, io.EOF):
+			return err
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			announce(line)
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+// openAtEnd opens a regular feed file positioned past what it holds.
+func openAtEnd(path string) (*os.File, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, offset, nil
+}
+
+// followFile announces lines appended to f past offset, polling for them.
+func followFile(ctx context.Context, f *os.File, offset int64, announce func(string)) error {
+	reader := bufio.NewReader(f)
+	var partial string
+	ticker := time.NewTicker(deployPollInterval)
+	defer ticker.Stop()
+	for {
+		line, err := reader.ReadString('\n')
+		offset += int64(len(line))
+		switch {
+		case err == nil:
+			if line = strings.TrimSpace(partial + line); line != "" {
+				announce(line)
+			}
+			partial = ""
+			continue
+		case !errors.Is(err, io.EOF):
+			return err
+		}
+		partial += line
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if info.Size() < offset {
+			// Truncated: start over from the beginning.
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("rewinding truncated feed: %w", err)
+			}
+			offset, partial = 0, ""
+			reader.Reset(f)
+		}
+	}
+}
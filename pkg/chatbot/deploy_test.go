@@ -0,0 +1,85 @@
+package chatbot
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestFollowFile tests that only lines appended after start are announced
+func TestFollowFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploys")
+	if err := os.WriteFile(path, []byte("old deploy\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, offset, err := openAtEnd(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lines := make(chan string, 4)
+	done := make(chan error, 1)
+	go func() { done <- followFile(ctx, f, offset, func(line string) { lines <- line }) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	w, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString("api v2")
+	w.WriteString(" to prod\n")
+	w.Close()
+
+	select {
+	case line := <-lines:
+		if line != "api v2 to prod" {
+			t.Errorf("Expected api v2 to prod, got %q", line)
+		}
+	case <-time.After(3 * deployPollInterval):
+		t.Fatal("Expected the appended line to be announced")
+	}
+}
+
+// TestFollowFIFOLongLine tests that an oversized line is skipped without
+// stopping the feed
+func TestFollowFIFOLongLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploys")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Skipf("Cannot create a FIFO: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	lines := make(chan string, 4)
+	done := make(chan error, 1)
+	go func() { done <- followFIFO(ctx, path, slog.Default(), func(line string) { lines <- line }) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Opening for writing waits for the feed to be open for reading.
+	w, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	go w.WriteString(strings.Repeat("x", 2*maxDeployLine) + "\napi v3 to prod\n")
+
+	select {
+	case line := <-lines:
+		if line != "api v3 to prod" {
+			t.Errorf("Expected api v3 to prod, got %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the line after the long one to be announced")
+	}
+}
@@ -0,0 +1,39 @@
+// Package chatbot has bots for the chat server: a clock, a link title
+// fetcher and a deploy announcer.
+package chatbot
+
+import (
+	"strings"
+	"time"
+
+	"github.com/insomnes/protohackers/pkg/chat"
+)
+
+// TimeBot answers "!time" with the server's time.
+type TimeBot struct {
+	name  string
+	rooms []string
+	now   func() time.Time
+}
+
+func NewTimeBot(name string, rooms []string) *TimeBot {
+	return &TimeBot{name: name, rooms: rooms, now: time.Now}
+}
+
+func (b *TimeBot) Name() string {
+	return b.name
+}
+
+func (b *TimeBot) Rooms() []string {
+	return b.rooms
+}
+
+func (b *TimeBot) Handle(c *chat.BotClient, m chat.Message) {
+	if m.Kind != chat.ChatMessage && m.Kind != chat.PrivateMessage {
+		return
+	}
+	if strings.TrimSpace(m.Text) != "!time" {
+		return
+	}
+	c.Reply(m, "It is "+b.now().Format(time.RFC1123))
+}
@@ -0,0 +1,138 @@
+package chatbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/insomnes/protohackers/pkg/chat"
+)
+
+const (
+	titleTimeout = 5 * time.Second
+	// maxTitlePage is how much of a page is searched for its title.
+	maxTitlePage = 256 << 10
+	maxTitleLen  = 200
+)
+
+var (
+	linkPattern  = regexp.MustCompile(`https?://[^\s<>"]+`)
+	titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+	errPrivateAddress = errors.New("refusing to fetch from a private address")
+)
+
+// TitleBot posts the title of web pages linked in its rooms.
+type TitleBot struct {
+	name   string
+	rooms  []string
+	client *http.Client
+}
+
+// NewTitleBot creates a bot that only fetches from public addresses,
+// unless allowPrivate is set, so links cannot be used to probe the
+// server's network.
+func NewTitleBot(name string, rooms []string, allowPrivate bool) *TitleBot {
+	dialer := &net.Dialer{Timeout: titleTimeout}
+	if !allowPrivate {
+		dialer.Control = refusePrivate
+	}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   titleTimeout,
+		ResponseHeaderTimeout: titleTimeout,
+	}
+	return &TitleBot{
+		name:   name,
+		rooms:  rooms,
+		client: &http.Client{Transport: transport, Timeout: titleTimeout},
+	}
+}
+
+func refusePrivate(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	addr := addrPort.Addr().Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+func (b *TitleBot) Name() string {
+	return b.name
+}
+
+func (b *TitleBot) Rooms() []string {
+	return b.rooms
+}
+
+func (b *TitleBot) Handle(c *chat.BotClient, m chat.Message) {
+	if m.Kind != chat.ChatMessage && m.Kind != chat.ActionMessage {
+		return
+	}
+	link := linkPattern.FindString(m.Text)
+	if link == "" {
+		return
+	}
+	title, err := b.fetchTitle(context.Background(), link)
+	if err != nil {
+		c.Logger().Info("No title for link", "link", link, "err", err)
+		return
+	}
+	c.Say(m.Room, "Title: "+title)
+}
+
+func (b *TitleBot) fetchTitle(ctx context.Context, link string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "text/html")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" {
+		return "", fmt.Errorf("not a page: %s", mediaType)
+	}
+	page, err := io.ReadAll(io.LimitReader(resp.Body, maxTitlePage))
+	if err != nil {
+		return "", err
+	}
+	title := extractTitle(string(page))
+	if title == "" {
+		return "", errors.New("page has no title")
+	}
+	return title, nil
+}
+
+// extractTitle returns the page title on a single line, shortened to
+// maxTitleLen runes.
+func extractTitle(page string) string {
+	match := titlePattern.FindStringSubmatch(page)
+	if match == nil {
+		return ""
+	}
+	title := strings.Join(strings.Fields(html.UnescapeString(match[1])), " ")
+	if runes := []rune(title); len(runes) > maxTitleLen {
+		title = string(runes[:maxTitleLen-1]) + "…"
+	}
+	return title
+}
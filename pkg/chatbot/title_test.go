@@ -0,0 +1,38 @@
+package chatbot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestExtractTitle tests entity decoding and whitespace folding of titles
+func TestExtractTitle(t *testing.T) {
+	page := "<html><head><TITLE lang=en>\n  Fish &amp; Chips\n\tShop </TITLE></head></html>"
+	if got := extractTitle(page); got != "Fish & Chips Shop" {
+		t.Errorf("Expected Fish & Chips Shop, got %q", got)
+	}
+	if got := extractTitle("<p>no title</p>"); got != "" {
+		t.Errorf("Expected no title, got %q", got)
+	}
+}
+
+// TestFetchTitlePrivate tests that private addresses are refused unless allowed
+func TestFetchTitlePrivate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<title>Local</title>"))
+	}))
+	defer srv.Close()
+
+	_, err := NewTitleBot("titlebot", nil, false).fetchTitle(context.Background(), srv.URL)
+	if !errors.Is(err, errPrivateAddress) {
+		t.Errorf("Expected errPrivateAddress, got %v", err)
+	}
+	title, err := NewTitleBot("titlebot", nil, true).fetchTitle(context.Background(), srv.URL)
+	if err != nil || title != "Local" {
+		t.Errorf("Expected title Local, got %q (%v)", title, err)
+	}
+}
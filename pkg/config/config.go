@@ -4,23 +4,40 @@ import (
 	"flag"
 	"log"
 	"time"
+
+	"github.com/insomnes/protohackers/pkg/limiter"
 )
 
 const (
-	DefaultHost         string = "localhost"
-	DefaultPort         uint16 = 9999
-	DefaultHandler      string = "echo"
-	DefaultReadTimeout  uint   = 20
-	DefaultWriteTimeout uint   = 20
+	DefaultHost            string = "localhost"
+	DefaultPort            uint16 = 9999
+	DefaultHandler         string = "echo"
+	DefaultReadTimeout     uint   = 20
+	DefaultWriteTimeout    uint   = 20
+	DefaultShutdownTimeout uint   = 10
+)
+
+const (
+	NetworkTCP = "tcp"
+	NetworkUDP = "udp"
 )
 
 type ServerConfig struct {
+	// Network is NetworkTCP (the default) or NetworkUDP.
+	Network      string
 	Host         string
 	Port         uint16
 	Handler      string
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
-	Verbose      bool
+	// ShutdownTimeout is how long active connections are drained before
+	// being force-closed on shutdown.
+	ShutdownTimeout time.Duration
+	// MetricsAddr enables the Prometheus /metrics endpoint when not empty.
+	MetricsAddr string
+	Limits      limiter.Config
+	// MaxMessageSize closes connections sending longer messages, 0 disables.
+	MaxMessageSize int
 }
 
 func ParseConfig() ServerConfig {
@@ -29,7 +46,10 @@ func ParseConfig() ServerConfig {
 	handler := flag.String("handler", DefaultHandler, "handler to use")
 	readTimeout := flag.Uint("read-timeout", DefaultReadTimeout, "read timeout")
 	writeTimeout := flag.Uint("write-timeout", DefaultWriteTimeout, "write timeout")
-	verbose := flag.Bool("verbose", false, "verbose output")
+	shutdownTimeout := flag.Uint("shutdown-timeout", DefaultShutdownTimeout, "connection drain timeout on shutdown")
+	metricsAddr := flag.String("metrics-addr", "", "address to serve /metrics on, disabled if empty")
+	maxMessageSize := flag.Int("max-message-size", 0, "max message size in bytes, 0 for unlimited")
+	limits := limiter.AddFlags()
 	flag.Parse()
 
 	if *port < 1 || *port > 65535 {
@@ -41,13 +61,19 @@ func ParseConfig() ServerConfig {
 	if *writeTimeout < 1 {
 		log.Fatalf("Invalid write timeout: %d", *writeTimeout)
 	}
+	if *shutdownTimeout < 1 {
+		log.Fatalf("Invalid shutdown timeout: %d", *shutdownTimeout)
+	}
 
 	return ServerConfig{
-		Host:         *host,
-		Port:         uint16(*port),
-		Handler:      *handler,
-		ReadTimeout:  time.Duration(*readTimeout) * time.Second,
-		WriteTimeout: time.Duration(*writeTimeout) * time.Second,
-		Verbose:      *verbose,
+		Host:            *host,
+		Port:            uint16(*port),
+		Handler:         *handler,
+		ReadTimeout:     time.Duration(*readTimeout) * time.Second,
+		WriteTimeout:    time.Duration(*writeTimeout) * time.Second,
+		ShutdownTimeout: time.Duration(*shutdownTimeout) * time.Second,
+		MetricsAddr:     *metricsAddr,
+		Limits:          *limits,
+		MaxMessageSize:  *maxMessageSize,
 	}
 }
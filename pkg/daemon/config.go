@@ -0,0 +1,91 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/insomnes/protohackers/pkg/config"
+	"github.com/insomnes/protohackers/pkg/logging"
+)
+
+const DefaultHost = "0.0.0.0"
+
+type Config struct {
+	ShutdownTimeout config.Duration `json:"shutdown_timeout"`
+	// MetricsAddr enables the shared Prometheus /metrics endpoint.
+	MetricsAddr string           `json:"metrics_addr"`
+	Log         logging.Options  `json:"log"`
+	Listeners   []ListenerConfig `json:"listeners"`
+}
+
+type ListenerConfig struct {
+	Name     string          `json:"name"`
+	Protocol string          `json:"protocol"`
+	Host     string          `json:"host"`
+	Port     uint16          `json:"port"`
+	Options  json.RawMessage `json:"options"`
+}
+
+func (lc ListenerConfig) Address() string {
+	return fmt.Sprintf("%s:%d", lc.Host, lc.Port)
+}
+
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config: %w", err)
+	}
+	if err := decodeStrict(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return cfg, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) validate() error {
+	if len(c.Listeners) == 0 {
+		return fmt.Errorf("no listeners configured")
+	}
+	if c.ShutdownTimeout.Duration <= 0 {
+		c.ShutdownTimeout.Duration = time.Duration(config.DefaultShutdownTimeout) * time.Second
+	}
+
+	names := make(map[string]struct{}, len(c.Listeners))
+	for i := range c.Listeners {
+		lc := &c.Listeners[i]
+		if _, ok := builders[lc.Protocol]; !ok {
+			return fmt.Errorf("listener %d: unknown protocol %q", i, lc.Protocol)
+		}
+		if lc.Port == 0 {
+			return fmt.Errorf("listener %d: port is required", i)
+		}
+		if lc.Host == "" {
+			lc.Host = DefaultHost
+		}
+		if lc.Name == "" {
+			lc.Name = fmt.Sprintf("%s:%d", lc.Protocol, lc.Port)
+		}
+		if _, dup := names[lc.Name]; dup {
+			return fmt.Errorf("listener %d: duplicate name %q", i, lc.Name)
+		}
+		names[lc.Name] = struct{}{}
+	}
+	return nil
+}
+
+// decodeStrict decodes JSON into v rejecting unknown fields, so typos in
+// the config are reported instead of silently ignored.
+func decodeStrict(data []byte, v any) error {
+	if len(data) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
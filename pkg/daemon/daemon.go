@@ -0,0 +1,107 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/insomnes/protohackers/pkg/metrics"
+)
+
+const (
+	minRestartDelay = 1 * time.Second
+	maxRestartDelay = 30 * time.Second
+	// A listener that ran at least this long is considered healthy again
+	// and its restart delay is reset.
+	stableRunTime = 1 * time.Minute
+)
+
+type listener struct {
+	name   string
+	runner Runner
+}
+
+// Daemon runs every configured listener side by side and restarts the
+// ones that fail, so one broken listener does not affect the rest.
+type Daemon struct {
+	listeners   []listener
+	metricsAddr string
+}
+
+func New(cfg Config) (*Daemon, error) {
+	d := &Daemon{metricsAddr: cfg.MetricsAddr}
+	for _, lc := range cfg.Listeners {
+		runner, err := builders[lc.Protocol](lc, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("listener %s: %w", lc.Name, err)
+		}
+		d.listeners = append(d.listeners, listener{name: lc.Name, runner: runner})
+	}
+	return d, nil
+}
+
+func (d *Daemon) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	if d.metricsAddr != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := metrics.Serve(ctx, d.metricsAddr); err != nil {
+				slog.Error("Metrics endpoint failed", "err", err)
+			}
+		}()
+	}
+	for _, l := range d.listeners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			supervise(ctx, l)
+		}()
+	}
+	wg.Wait()
+	slog.Info("All listeners stopped")
+}
+
+func supervise(ctx context.Context, l listener) {
+	var delay time.Duration
+	for {
+		slog.Info("Starting listener", "listener", l.name)
+		started := time.Now()
+		err := runSafely(ctx, l.runner)
+		if ctx.Err() != nil {
+			slog.Info("Listener stopped", "listener", l.name)
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("stopped unexpectedly")
+		}
+		delay = restartDelay(delay, time.Since(started))
+		slog.Error("Listener failed", "listener", l.name, "err", err, "restart_in", delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// restartDelay is the wait before restarting a listener that failed after
+// running for ran, given the previous wait or zero for the first failure.
+func restartDelay(last, ran time.Duration) time.Duration {
+	if last == 0 || ran >= stableRunTime {
+		return minRestartDelay
+	}
+	return min(last*2, maxRestartDelay)
+}
+
+func runSafely(ctx context.Context, runner Runner) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return runner.Run(ctx)
+}
@@ -0,0 +1,70 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLoadConfig tests defaults and the reason each bad config is refused
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		reason string
+	}{
+		{"empty", `{}`, "no listeners configured"},
+		{"unknown field", `{"listenres": []}`, `unknown field "listenres"`},
+		{"unknown protocol", `{"listeners": [{"protocol": "ftp", "port": 21}]}`, `unknown protocol "ftp"`},
+		{"no port", `{"listeners": [{"protocol": "echo"}]}`, "port is required"},
+		{
+			"duplicate name",
+			`{"listeners": [{"protocol": "echo", "port": 1}, {"name": "echo:1", "protocol": "prime", "port": 2}]}`,
+			`duplicate name "echo:1"`,
+		},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "phd.json")
+		if err := os.WriteFile(path, []byte(tt.config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadConfig(path)
+		if err == nil || !strings.Contains(err.Error(), tt.reason) {
+			t.Errorf("Expected %s config to fail with %q, got %v", tt.name, tt.reason, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "phd.json")
+	if err := os.WriteFile(path, []byte(`{"listeners": [{"protocol": "chat", "port": 9101}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Expected config to load, got %v", err)
+	}
+	lc := cfg.Listeners[0]
+	if lc.Host != DefaultHost || lc.Name != "chat:9101" {
+		t.Errorf("Expected default host and name, got %q, %q", lc.Host, lc.Name)
+	}
+	if cfg.ShutdownTimeout.Duration <= 0 {
+		t.Errorf("Expected a default shutdown timeout, got %v", cfg.ShutdownTimeout.Duration)
+	}
+}
+
+// TestRestartDelay tests that restarts back off up to the limit and start
+// over after a stable run
+func TestRestartDelay(t *testing.T) {
+	var delay time.Duration
+	want := []time.Duration{1, 2, 4, 8, 16, 30, 30}
+	for i, seconds := range want {
+		delay = restartDelay(delay, time.Second)
+		if delay != seconds*time.Second {
+			t.Errorf("Expected restart %d after %v, got %v", i+1, seconds*time.Second, delay)
+		}
+	}
+	if delay = restartDelay(delay, stableRunTime); delay != minRestartDelay {
+		t.Errorf("Expected %v after a stable run, got %v", minRestartDelay, delay)
+	}
+}
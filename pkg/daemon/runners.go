@@ -0,0 +1,102 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/insomnes/protohackers/pkg/chat"
+	"github.com/insomnes/protohackers/pkg/config"
+	"github.com/insomnes/protohackers/pkg/handlers"
+	"github.com/insomnes/protohackers/pkg/kvstore"
+	"github.com/insomnes/protohackers/pkg/limiter"
+	"github.com/insomnes/protohackers/pkg/mitm"
+	"github.com/insomnes/protohackers/pkg/server"
+)
+
+// Runner is a listener the daemon supervises. Run blocks until ctx is
+// cancelled or the listener fails.
+type Runner interface {
+	Run(ctx context.Context) error
+}
+
+type builder func(lc ListenerConfig, cfg Config) (Runner, error)
+
+var builders = map[string]builder{
+	"echo":  newHandlerServer,
+	"prime": newHandlerServer,
+	"means": newHandlerServer,
+	"chat":  newChatServer,
+	"kv":    newKVServer,
+	"mitm":  newMitmServer,
+}
+
+type handlerOptions struct {
+	ReadTimeout  config.Duration `json:"read_timeout"`
+	WriteTimeout config.Duration `json:"write_timeout"`
+	Limits       limiter.Config  `json:"limits"`
+	// MaxMessageSize in bytes, 0 for unlimited.
+	MaxMessageSize int `json:"max_message_size"`
+}
+
+func decodeHandlerOptions(lc ListenerConfig, cfg Config) (config.ServerConfig, error) {
+	opts := handlerOptions{
+		ReadTimeout:  config.Duration{Duration: time.Duration(config.DefaultReadTimeout) * time.Second},
+		WriteTimeout: config.Duration{Duration: time.Duration(config.DefaultWriteTimeout) * time.Second},
+	}
+	if err := decodeStrict(lc.Options, &opts); err != nil {
+		return config.ServerConfig{}, fmt.Errorf("invalid options: %w", err)
+	}
+
+	return config.ServerConfig{
+		Host:            lc.Host,
+		Port:            lc.Port,
+		Handler:         lc.Protocol,
+		ReadTimeout:     opts.ReadTimeout.Duration,
+		WriteTimeout:    opts.WriteTimeout.Duration,
+		ShutdownTimeout: cfg.ShutdownTimeout.Duration,
+		Limits:          opts.Limits,
+		MaxMessageSize:  opts.MaxMessageSize,
+	}, nil
+}
+
+func newHandlerServer(lc ListenerConfig, cfg Config) (Runner, error) {
+	handler, ok := handlers.GetHandler(lc.Protocol)
+	if !ok {
+		return nil, fmt.Errorf("unknown handler %q", lc.Protocol)
+	}
+	srvCfg, err := decodeHandlerOptions(lc, cfg)
+	if err != nil {
+		return nil, err
+	}
+	handler = server.Chain(handler, server.DefaultMiddlewares(srvCfg)...)
+	return server.NewServer(srvCfg, handler), nil
+}
+
+func newChatServer(lc ListenerConfig, cfg Config) (Runner, error) {
+	chatCfg := chat.DefaultConfig()
+	if err := decodeStrict(lc.Options, &chatCfg); err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+	chatCfg.Address = lc.Address()
+	chatServer := chat.NewChatServer(chatCfg)
+	return &chatServer, nil
+}
+
+func newKVServer(lc ListenerConfig, cfg Config) (Runner, error) {
+	srvCfg, err := decodeHandlerOptions(lc, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return kvstore.NewKVServer(srvCfg), nil
+}
+
+func newMitmServer(lc ListenerConfig, cfg Config) (Runner, error) {
+	mitmCfg := mitm.Config{ChatAddr: mitm.DefaultChatAddr}
+	if err := decodeStrict(lc.Options, &mitmCfg); err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+	mitmCfg.Address = lc.Address()
+	mitmServer := mitm.NewMitmServer(mitmCfg)
+	return &mitmServer, nil
+}
@@ -1,7 +1,7 @@
 package handlers
 
 import (
-	"fmt"
+	"log/slog"
 	"net"
 
 	"github.com/insomnes/protohackers/pkg/server"
@@ -14,17 +14,14 @@ func (e *EchoHandler) GetReader(conn net.Conn) server.MsgReader {
 	return &reader
 }
 
-func (e *EchoHandler) GetMsgHandler(conn net.Conn, verbose bool) server.MsgHandler {
-	return &EchoMsgHandler{verbose: verbose}
+func (e *EchoHandler) GetMsgHandler(conn net.Conn, logger *slog.Logger) server.MsgHandler {
+	return &EchoMsgHandler{logger: logger}
 }
 
 type EchoMsgHandler struct {
-	verbose bool
+	logger *slog.Logger
 }
 
 func (em *EchoMsgHandler) HandleMessage(msg []byte) ([]byte, error) {
-	if em.verbose {
-		fmt.Print("Echoing message: ", string(msg))
-	}
 	return msg, nil
 }
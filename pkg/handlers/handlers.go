@@ -0,0 +1,15 @@
+package handlers
+
+import "github.com/insomnes/protohackers/pkg/server"
+
+var handlerMap = map[string]server.ConnHandler{
+	"echo":  &EchoHandler{},
+	"prime": &PrimeHandler{},
+	"means": &MeansHandler{},
+}
+
+// GetHandler returns the ConnHandler registered under name.
+func GetHandler(name string) (server.ConnHandler, bool) {
+	handler, ok := handlerMap[name]
+	return handler, ok
+}
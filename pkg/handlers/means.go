@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"fmt"
+	"log/slog"
 	"net"
 
 	"github.com/insomnes/protohackers/pkg/server"
@@ -9,33 +10,31 @@ import (
 
 type MeansHandler struct{}
 
+// meansFrameSizes are the body sizes of insert and query messages.
+var meansFrameSizes = map[byte]int{'I': 8, 'Q': 8}
+
 func (mh *MeansHandler) GetReader(conn net.Conn) server.MsgReader {
-	reader := NewNBytesReader(conn, 9)
+	reader := NewTypedReader(conn, meansFrameSizes, 9)
 	return &reader
 }
 
-func (mh *MeansHandler) GetMsgHandler(conn net.Conn, verbose bool) server.MsgHandler {
-	return NewMeansMsgHandler(verbose, conn.RemoteAddr().String())
+func (mh *MeansHandler) GetMsgHandler(conn net.Conn, logger *slog.Logger) server.MsgHandler {
+	return NewMeansMsgHandler(logger)
 }
 
 type MeansMsgHandler struct {
-	verbose bool
-	db      *BST
-	remote  string
+	logger *slog.Logger
+	db     *BST
 }
 
-func NewMeansMsgHandler(verbose bool, remote string) *MeansMsgHandler {
+func NewMeansMsgHandler(logger *slog.Logger) *MeansMsgHandler {
 	return &MeansMsgHandler{
-		verbose: verbose,
-		db:      &BST{root: nil, valCnt: 0},
-		remote:  remote,
+		logger: logger,
+		db:     &BST{root: nil, valCnt: 0},
 	}
 }
 
 func (mh *MeansMsgHandler) HandleMessage(msg []byte) ([]byte, error) {
-	if mh.verbose {
-		fmt.Printf("%s->Parsing message: %v\n", mh.remote, msg)
-	}
 	msgType := msg[0]
 	switch msgType {
 	case byte('Q'):
@@ -55,7 +54,7 @@ func (mh *MeansMsgHandler) handleQuery(msg []byte) ([]byte, error) {
 		}
 		return nil, err
 	}
-	fmt.Println(mh.remote, "->Querying", query.from, query.to)
+	mh.logger.Info("Querying", "from", query.from, "to", query.to)
 	all := mh.db.Search(query.from, query.to)
 	if len(all) == 0 {
 		return []byte{0, 0, 0, 0}, nil
@@ -64,13 +63,8 @@ func (mh *MeansMsgHandler) handleQuery(msg []byte) ([]byte, error) {
 	for _, v := range all {
 		sum += int(v)
 	}
-	if mh.verbose {
-		fmt.Println(mh.remote, "->Sum:", sum)
-	}
 	mean := sum / len(all)
-	if mh.verbose {
-		fmt.Println("Mean:", mean)
-	}
+	mh.logger.Debug("Query result", "sum", sum, "mean", mean)
 	buf := make([]byte, 4)
 	buf[0] = byte(mean >> 24)
 	buf[1] = byte(mean >> 16)
@@ -85,7 +79,7 @@ func (mh *MeansMsgHandler) handleInsert(msg []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println(mh.remote, "->Inserting", insert.ts, insert.value)
+	mh.logger.Info("Inserting", "ts", insert.ts, "value", insert.value)
 	mh.db.Insert(insert.ts, insert.value)
 	return nil, nil
 }
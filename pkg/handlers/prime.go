@@ -3,8 +3,8 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net"
-	"os"
 	"strings"
 
 	"github.com/insomnes/protohackers/pkg/server"
@@ -31,7 +31,7 @@ func isNumberError(err error) bool {
 	return false
 }
 
-func parseInput(buffer []byte) (Input, error) {
+func parseInput(buffer []byte, logger *slog.Logger) (Input, error) {
 	var input Input
 	err := json.Unmarshal(buffer, &input)
 	if err != nil {
@@ -42,7 +42,7 @@ func parseInput(buffer []byte) (Input, error) {
 		if isFloatError(err) {
 			return input, fmt.Errorf("float")
 		}
-		fmt.Fprintln(os.Stderr, "Possibly too big number in:", err)
+		logger.Warn("Possibly too big number", "err", err)
 	}
 	if input.Method != "isPrime" {
 		return input, fmt.Errorf("not-prime")
@@ -73,6 +73,9 @@ func isPrime(n uint) bool {
 	return true
 }
 
+// maxPrimeRequest leaves room for requests with very big numbers.
+const maxPrimeRequest = 1024 * 1024
+
 var (
 	malformedResponse []byte = []byte("{}\n")
 	falseResponse     []byte = []byte(`{"method":"isPrime","prime":false}` + "\n")
@@ -82,50 +85,47 @@ var (
 type PrimeHandler struct{}
 
 func (ph *PrimeHandler) GetReader(conn net.Conn) server.MsgReader {
-	reader := NewLineReader(conn)
+	reader := NewLineReader(conn, maxPrimeRequest)
 	return &reader
 }
 
-func (ph *PrimeHandler) GetMsgHandler(conn net.Conn, verbose bool) server.MsgHandler {
-	return &PrimeMessageHandler{verbose: verbose}
+func (ph *PrimeHandler) GetMsgHandler(conn net.Conn, logger *slog.Logger) server.MsgHandler {
+	return &PrimeMessageHandler{logger: logger}
+}
+
+// RefuseMessage answers refused clients the same way as malformed requests.
+func (ph *PrimeHandler) RefuseMessage(err error) []byte {
+	return malformedResponse
 }
 
 type PrimeMessageHandler struct {
-	verbose bool
+	logger *slog.Logger
 }
 
 func (pmh *PrimeMessageHandler) HandleMessage(msg []byte) ([]byte, error) {
-	if len(msg) > 1 && pmh.verbose {
-		fmt.Print("Prime message: ", string(msg))
-	}
-
-	input, err := parseInput(msg)
+	input, err := parseInput(msg, pmh.logger)
 	if err != nil {
 		switch err.Error() {
 		case "invalid":
-			fmt.Fprintln(os.Stderr, "Invalid json")
+			pmh.logger.Warn("Invalid json")
 			return malformedResponse, nil
 		case "not-prime":
-			fmt.Fprintln(os.Stderr, "Invalid method")
+			pmh.logger.Warn("Invalid method")
 			return malformedResponse, nil
 		case "float":
-			fmt.Fprintln(os.Stderr, "Invalid number")
+			pmh.logger.Warn("Invalid number")
 			return falseResponse, nil
 		default:
-			fmt.Fprintln(os.Stderr, "Other error", err)
+			pmh.logger.Warn("Other error", "err", err)
 			return malformedResponse, nil
 		}
 	}
 	inputIsPrime := isPrime(*input.Number)
 
 	if !inputIsPrime {
-		if pmh.verbose {
-			fmt.Println("Got non-prime number:", *input.Number)
-		}
+		pmh.logger.Debug("Got non-prime number", "number", *input.Number)
 		return falseResponse, nil
 	}
-	if pmh.verbose {
-		fmt.Println("Got prime number:", *input.Number)
-	}
+	pmh.logger.Debug("Got prime number", "number", *input.Number)
 	return trueResponse, nil
 }
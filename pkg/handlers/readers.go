@@ -2,8 +2,14 @@ package handlers
 
 import (
 	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"net"
+
+	"github.com/insomnes/protohackers/pkg/lineio"
+	"github.com/insomnes/protohackers/pkg/server"
 )
 
 type FullReader struct {
@@ -23,16 +29,22 @@ func (f *FullReader) ReadMessage() ([]byte, error) {
 	return buf[:n], nil
 }
 
+// LineReader reads newline terminated messages of at most max bytes and
+// fails on longer ones, which closes the connection.
 type LineReader struct {
-	bufr *bufio.Reader
+	reader *lineio.Reader
 }
 
-func NewLineReader(conn net.Conn) LineReader {
-	return LineReader{bufr: bufio.NewReader(conn)}
+func NewLineReader(conn net.Conn, max int) LineReader {
+	return LineReader{reader: lineio.NewReader(conn, maxOrDefault(max), lineio.Disconnect)}
 }
 
 func (l *LineReader) ReadMessage() ([]byte, error) {
-	return l.bufr.ReadBytes('\n')
+	msg, err := l.reader.ReadBytes()
+	if errors.Is(err, lineio.ErrLineTooLong) {
+		return nil, fmt.Errorf("%w: %w", server.ErrMessageTooLarge, err)
+	}
+	return msg, err
 }
 
 type NBytesReader struct {
@@ -52,3 +64,164 @@ func (n *NBytesReader) ReadMessage() ([]byte, error) {
 	}
 	return buf, nil
 }
+
+// DefaultMaxFrameSize is the max message size used by framing readers
+// when none is given.
+const DefaultMaxFrameSize = 64 * 1024
+
+var (
+	ErrFrameTooLarge    = errors.New("frame too large")
+	ErrUnknownFrameType = errors.New("unknown frame type")
+)
+
+// FrameTooLargeError is returned by framing readers when a frame exceeds
+// their max size. It matches ErrFrameTooLarge and server.ErrMessageTooLarge
+// with errors.Is.
+type FrameTooLargeError struct {
+	Size int
+	Max  int
+}
+
+func (e *FrameTooLargeError) Error() string {
+	return fmt.Sprintf("frame of %d bytes exceeds max of %d", e.Size, e.Max)
+}
+
+func (e *FrameTooLargeError) Is(target error) bool {
+	return target == ErrFrameTooLarge || target == server.ErrMessageTooLarge
+}
+
+func maxOrDefault(max int) int {
+	if max <= 0 {
+		return DefaultMaxFrameSize
+	}
+	return max
+}
+
+// PrefixSize is the width of a big-endian length prefix.
+type PrefixSize int
+
+const (
+	U8Prefix  PrefixSize = 1
+	U16Prefix PrefixSize = 2
+	U32Prefix PrefixSize = 4
+)
+
+// LengthPrefixReader reads frames made of a big-endian length followed
+// by that many bytes. Only the body is returned.
+type LengthPrefixReader struct {
+	bufr   *bufio.Reader
+	prefix PrefixSize
+	max    int
+}
+
+func NewLengthPrefixReader(r io.Reader, prefix PrefixSize, max int) LengthPrefixReader {
+	switch prefix {
+	case U8Prefix, U16Prefix, U32Prefix:
+	default:
+		panic(fmt.Sprintf("invalid length prefix size: %d", prefix))
+	}
+	return LengthPrefixReader{
+		bufr:   bufio.NewReader(r),
+		prefix: prefix,
+		max:    maxOrDefault(max),
+	}
+}
+
+func (l *LengthPrefixReader) ReadMessage() ([]byte, error) {
+	prefix := make([]byte, l.prefix)
+	if _, err := io.ReadFull(l.bufr, prefix); err != nil {
+		return nil, err
+	}
+
+	var size uint64
+	switch l.prefix {
+	case U8Prefix:
+		size = uint64(prefix[0])
+	case U16Prefix:
+		size = uint64(binary.BigEndian.Uint16(prefix))
+	case U32Prefix:
+		size = uint64(binary.BigEndian.Uint32(prefix))
+	}
+	if size > uint64(l.max) {
+		return nil, &FrameTooLargeError{Size: int(size), Max: l.max}
+	}
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(l.bufr, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// DelimReader reads frames ending with an arbitrary delimiter byte. The
+// delimiter is included in the returned message and counts towards max.
+type DelimReader struct {
+	bufr  *bufio.Reader
+	delim byte
+	max   int
+}
+
+func NewDelimReader(r io.Reader, delim byte, max int) DelimReader {
+	return DelimReader{
+		bufr:  bufio.NewReader(r),
+		delim: delim,
+		max:   maxOrDefault(max),
+	}
+}
+
+func (d *DelimReader) ReadMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		chunk, err := d.bufr.ReadSlice(d.delim)
+		if len(msg)+len(chunk) > d.max {
+			return nil, &FrameTooLargeError{Size: len(msg) + len(chunk), Max: d.max}
+		}
+		msg = append(msg, chunk...)
+		switch {
+		case err == nil:
+			return msg, nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		default:
+			return nil, err
+		}
+	}
+}
+
+// TypedReader reads frames made of a type byte followed by a fixed size
+// body, the size depending on the type. The type byte is included in the
+// returned message.
+type TypedReader struct {
+	bufr  *bufio.Reader
+	sizes map[byte]int
+	max   int
+}
+
+func NewTypedReader(r io.Reader, sizes map[byte]int, max int) TypedReader {
+	return TypedReader{
+		bufr:  bufio.NewReader(r),
+		sizes: sizes,
+		max:   maxOrDefault(max),
+	}
+}
+
+func (t *TypedReader) ReadMessage() ([]byte, error) {
+	msgType, err := t.bufr.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	size, ok := t.sizes[msgType]
+	if !ok {
+		return nil, fmt.Errorf("%w: 0x%02x", ErrUnknownFrameType, msgType)
+	}
+	if size+1 > t.max {
+		return nil, &FrameTooLargeError{Size: size + 1, Max: t.max}
+	}
+
+	buf := make([]byte, size+1)
+	buf[0] = msgType
+	if _, err := io.ReadFull(t.bufr, buf[1:]); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
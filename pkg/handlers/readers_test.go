@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestLengthPrefixReader tests u8, u16 and u32 length prefixes.
+func TestLengthPrefixReader(t *testing.T) {
+	cases := []struct {
+		prefix PrefixSize
+		input  []byte
+	}{
+		{U8Prefix, []byte{3, 'a', 'b', 'c', 0}},
+		{U16Prefix, []byte{0, 3, 'a', 'b', 'c', 0, 0}},
+		{U32Prefix, []byte{0, 0, 0, 3, 'a', 'b', 'c', 0, 0, 0, 0}},
+	}
+	for _, c := range cases {
+		reader := NewLengthPrefixReader(bytes.NewReader(c.input), c.prefix, 10)
+		msg, err := reader.ReadMessage()
+		if err != nil || string(msg) != "abc" {
+			t.Errorf("Prefix %d: expected abc, got %q (%v)", c.prefix, msg, err)
+		}
+		msg, err = reader.ReadMessage()
+		if err != nil || len(msg) != 0 {
+			t.Errorf("Prefix %d: expected empty frame, got %q (%v)", c.prefix, msg, err)
+		}
+		if _, err := reader.ReadMessage(); err != io.EOF {
+			t.Errorf("Prefix %d: expected EOF, got %v", c.prefix, err)
+		}
+	}
+
+	reader := NewLengthPrefixReader(bytes.NewReader([]byte{0, 0, 1, 0}), U32Prefix, 10)
+	_, err := reader.ReadMessage()
+	var tooLarge *FrameTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Size != 256 {
+		t.Errorf("Expected FrameTooLargeError of 256, got %v", err)
+	}
+}
+
+// TestDelimReader tests delimiters and the max size guard.
+func TestDelimReader(t *testing.T) {
+	reader := NewDelimReader(strings.NewReader("ab;cd;"), ';', 3)
+	for _, expected := range []string{"ab;", "cd;"} {
+		msg, err := reader.ReadMessage()
+		if err != nil || string(msg) != expected {
+			t.Errorf("Expected %q, got %q (%v)", expected, msg, err)
+		}
+	}
+
+	long := strings.Repeat("x", 10000) + "\n"
+	reader = NewDelimReader(strings.NewReader(long), '\n', 5000)
+	if _, err := reader.ReadMessage(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("Expected ErrFrameTooLarge, got %v", err)
+	}
+}
+
+// TestTypedReader tests per-type body sizes.
+func TestTypedReader(t *testing.T) {
+	sizes := map[byte]int{'A': 2, 'B': 0}
+	reader := NewTypedReader(strings.NewReader("AxyBC"), sizes, 10)
+	for _, expected := range []string{"Axy", "B"} {
+		msg, err := reader.ReadMessage()
+		if err != nil || string(msg) != expected {
+			t.Errorf("Expected %q, got %q (%v)", expected, msg, err)
+		}
+	}
+	if _, err := reader.ReadMessage(); !errors.Is(err, ErrUnknownFrameType) {
+		t.Errorf("Expected ErrUnknownFrameType, got %v", err)
+	}
+}
@@ -1,11 +1,9 @@
 package kvstore
 
 import (
-	"context"
 	"fmt"
-	"log"
-	"net"
 	"strings"
+	"sync"
 )
 
 type (
@@ -34,28 +32,26 @@ type Query struct {
 	Type QueryType
 	Key  Key
 	Val  Value
-	From net.UDPAddr
 }
 
 func (q Query) String() string {
-	return fmt.Sprintf("Query: %s, %s, %s, %s", q.Type, q.Key, q.Val, q.From.String())
+	return fmt.Sprintf("Query: %s, %s, %s", q.Type, q.Key, q.Val)
 }
 
-func NewQuery(qType QueryType, key string, val string, from net.UDPAddr) Query {
+func NewQuery(qType QueryType, key string, val string) Query {
 	return Query{
 		Type: qType,
 		Key:  Key(key),
 		Val:  Value(val),
-		From: from,
 	}
 }
 
-func QueryFromBytes(b []byte, from net.UDPAddr) Query {
+func QueryFromBytes(b []byte) Query {
 	var qType QueryType
 
 	key, offset := extractKey(b)
 	if offset != -1 {
-		return NewQuery(Insert, key, string(b[offset:]), from)
+		return NewQuery(Insert, key, string(b[offset:]))
 	}
 
 	if key == versionKey {
@@ -63,7 +59,7 @@ func QueryFromBytes(b []byte, from net.UDPAddr) Query {
 	} else {
 		qType = Retrieve
 	}
-	return NewQuery(qType, key, "", from)
+	return NewQuery(qType, key, "")
 }
 
 // Returning key and '=' offset (i + 1 to skip it) or -1 if not found
@@ -83,7 +79,6 @@ func extractKey(b []byte) (string, int) {
 type Response struct {
 	Key Key
 	Val Value
-	To  net.UDPAddr
 }
 
 func (r Response) Bytes() []byte {
@@ -91,63 +86,46 @@ func (r Response) Bytes() []byte {
 }
 
 func (r Response) String() string {
-	return fmt.Sprintf("Response: %s, %s, %s", r.Key, r.Val, r.To.String())
+	return fmt.Sprintf("Response: %s, %s", r.Key, r.Val)
 }
 
 type DB struct {
+	mu      sync.Mutex
 	storage map[Key]Value
-
-	queries chan Query
 }
 
 func NewDB() *DB {
 	return &DB{
 		storage: make(map[Key]Value),
-		queries: make(chan Query, EventChannelSize),
-	}
-}
-
-func (db *DB) Run(ctx context.Context, results chan Response) {
-	log.Println("Running DB")
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("DB shutting down")
-			return
-		case q := <-db.queries:
-			db.handleQuery(q, results)
-		}
-	}
-}
-
-func (db *DB) QueueQuery(q Query) {
-	if q.Type == Insert && q.Key == versionKey {
-		return
 	}
-	db.queries <- q
 }
 
-func (db *DB) handleQuery(q Query, results chan Response) {
+// Query runs q and returns the response to send back, if there is one.
+func (db *DB) Query(q Query) (Response, bool) {
 	switch q.Type {
 	case Retrieve:
-		val := db.retrieve(q.Key)
-		results <- Response{Key: q.Key, Val: val, To: q.From}
+		return Response{Key: q.Key, Val: db.retrieve(q.Key)}, true
 	case Insert:
 		if q.Key == versionKey {
-			return
+			return Response{}, false
 		}
 		db.insert(q.Key, q.Val)
+		return Response{}, false
 	case VersionReq:
-		val := db.version()
-		results <- Response{Key: q.Key, Val: val, To: q.From}
+		return Response{Key: q.Key, Val: db.version()}, true
 	}
+	return Response{}, false
 }
 
 func (db *DB) insert(key Key, val Value) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 	db.storage[key] = val
 }
 
 func (db *DB) retrieve(key Key) Value {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 	return db.storage[key]
 }
 
@@ -0,0 +1,49 @@
+package limiter
+
+import "time"
+
+// TokenBucket allows bursts of up to burst events and refills at rate
+// tokens per second. It is not safe for concurrent use.
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate float64, burst int, now time.Time) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+// Allow takes a token if one is available.
+func (b *TokenBucket) Allow(now time.Time) bool {
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Full reports whether the bucket has refilled completely, meaning it
+// carries no state worth keeping.
+func (b *TokenBucket) Full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
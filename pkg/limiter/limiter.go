@@ -0,0 +1,150 @@
+package limiter
+
+import (
+	"errors"
+	"flag"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTooManyConns      = errors.New("too many connections")
+	ErrTooManyConnsPerIP = errors.New("too many connections from this address")
+	ErrRateLimited       = errors.New("connecting too fast")
+)
+
+// sweepInterval is how often idle per-IP buckets are dropped.
+const sweepInterval = time.Minute
+
+// Config holds connection limits. Zero values mean unlimited.
+type Config struct {
+	MaxConns      int     `json:"max_conns"`
+	MaxConnsPerIP int     `json:"max_conns_per_ip"`
+	RatePerIP     float64 `json:"rate_per_ip"`
+	BurstPerIP    int     `json:"burst_per_ip"`
+}
+
+// AddFlags registers the limit flags on the default flag set. The
+// returned config is filled in by flag.Parse.
+func AddFlags() *Config {
+	cfg := &Config{}
+	flag.IntVar(&cfg.MaxConns, "max-conns", 0, "max concurrent connections, 0 for unlimited")
+	flag.IntVar(&cfg.MaxConnsPerIP, "max-conns-per-ip", 0, "max concurrent connections per remote IP, 0 for unlimited")
+	flag.Float64Var(&cfg.RatePerIP, "conn-rate", 0, "new connections per remote IP per second, 0 for unlimited")
+	flag.IntVar(&cfg.BurstPerIP, "conn-burst", 1, "burst of new connections per remote IP allowed by --conn-rate")
+	return cfg
+}
+
+// Reason returns a short label for a limiter error, used in metrics.
+func Reason(err error) string {
+	switch {
+	case errors.Is(err, ErrTooManyConns):
+		return "max_conns"
+	case errors.Is(err, ErrTooManyConnsPerIP):
+		return "max_conns_per_ip"
+	case errors.Is(err, ErrRateLimited):
+		return "rate"
+	default:
+		return "other"
+	}
+}
+
+type Limiter struct {
+	cfg Config
+
+	mu        sync.Mutex
+	total     int
+	perIP     map[string]int
+	buckets   map[string]*TokenBucket
+	lastSweep time.Time
+}
+
+func New(cfg Config) *Limiter {
+	return &Limiter{
+		cfg:       cfg,
+		perIP:     make(map[string]int),
+		buckets:   make(map[string]*TokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Accept checks conn against the limits. On success it returns conn
+// wrapped so that closing it releases its slot.
+func (l *Limiter) Accept(conn net.Conn) (net.Conn, error) {
+	ip := remoteIP(conn.RemoteAddr())
+	if err := l.acquire(ip, time.Now()); err != nil {
+		return nil, err
+	}
+	return &limitedConn{Conn: conn, release: func() { l.release(ip) }}, nil
+}
+
+func (l *Limiter) acquire(ip string, now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	if l.cfg.MaxConns > 0 && l.total >= l.cfg.MaxConns {
+		return ErrTooManyConns
+	}
+	if l.cfg.MaxConnsPerIP > 0 && l.perIP[ip] >= l.cfg.MaxConnsPerIP {
+		return ErrTooManyConnsPerIP
+	}
+	if l.cfg.RatePerIP > 0 {
+		bucket, ok := l.buckets[ip]
+		if !ok {
+			bucket = NewTokenBucket(l.cfg.RatePerIP, l.cfg.BurstPerIP, now)
+			l.buckets[ip] = bucket
+		}
+		if !bucket.Allow(now) {
+			return ErrRateLimited
+		}
+	}
+
+	l.total++
+	l.perIP[ip]++
+	return nil
+}
+
+func (l *Limiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	l.perIP[ip]--
+	if l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for ip, bucket := range l.buckets {
+		if bucket.Full(now) {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+func remoteIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (lc *limitedConn) Close() error {
+	err := lc.Conn.Close()
+	lc.once.Do(lc.release)
+	return err
+}
@@ -0,0 +1,93 @@
+package limiter
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestTokenBucket tests burst and refill of the token bucket.
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	bucket := NewTokenBucket(2, 3, now)
+
+	for i := range 3 {
+		if !bucket.Allow(now) {
+			t.Fatalf("Expected token %d to be allowed", i)
+		}
+	}
+	if bucket.Allow(now) {
+		t.Error("Expected burst to be exhausted")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if !bucket.Allow(now) {
+		t.Error("Expected one token after 500ms at 2/s")
+	}
+	if bucket.Allow(now) {
+		t.Error("Expected only one token after 500ms at 2/s")
+	}
+}
+
+// TestAcquire tests global, per-IP and rate limits.
+func TestAcquire(t *testing.T) {
+	now := time.Now()
+	l := New(Config{MaxConns: 3, MaxConnsPerIP: 2})
+
+	if err := l.acquire("a", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.acquire("a", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.acquire("a", now); !errors.Is(err, ErrTooManyConnsPerIP) {
+		t.Errorf("Expected per-IP limit, got %v", err)
+	}
+	if err := l.acquire("b", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.acquire("c", now); !errors.Is(err, ErrTooManyConns) {
+		t.Errorf("Expected global limit, got %v", err)
+	}
+	l.release("a")
+	if err := l.acquire("c", now); err != nil {
+		t.Errorf("Expected slot after release, got %v", err)
+	}
+
+	l = New(Config{RatePerIP: 1, BurstPerIP: 1})
+	if err := l.acquire("a", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.acquire("a", now); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected rate limit, got %v", err)
+	}
+	if err := l.acquire("a", now.Add(time.Second)); err != nil {
+		t.Errorf("Expected token after refill, got %v", err)
+	}
+}
+
+// TestRefuse tests that refusals are written while few are in flight and
+// dropped silently once too many are
+func TestRefuse(t *testing.T) {
+	server, client := net.Pipe()
+	Refuse(server, []byte("busy\n"))
+	if got, _ := io.ReadAll(client); string(got) != "busy\n" {
+		t.Errorf("Expected busy, got %q", got)
+	}
+
+	for range maxRefusing {
+		refusing <- struct{}{}
+	}
+	defer func() {
+		for range maxRefusing {
+			<-refusing
+		}
+	}()
+	server, client = net.Pipe()
+	Refuse(server, []byte("busy\n"))
+	if got, _ := io.ReadAll(client); len(got) != 0 {
+		t.Errorf("Expected no message when full, got %q", got)
+	}
+}
@@ -0,0 +1,38 @@
+package limiter
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	// RefuseTimeout bounds the write of a refusal message.
+	RefuseTimeout = time.Second
+	// maxRefusing bounds the refusals written at once, so a flood of
+	// refused connections cannot pile up goroutines and descriptors.
+	maxRefusing = 64
+)
+
+var refusing = make(chan struct{}, maxRefusing)
+
+// Refuse closes conn after telling the client msg. The write happens in
+// the background with a short deadline; when too many are in flight
+// already conn is closed without a word.
+func Refuse(conn net.Conn, msg []byte) {
+	if len(msg) == 0 {
+		conn.Close()
+		return
+	}
+	select {
+	case refusing <- struct{}{}:
+	default:
+		conn.Close()
+		return
+	}
+	go func() {
+		defer func() { <-refusing }()
+		defer conn.Close()
+		conn.SetWriteDeadline(time.Now().Add(RefuseTimeout))
+		conn.Write(msg)
+	}()
+}
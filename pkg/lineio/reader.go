@@ -0,0 +1,131 @@
+package lineio
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var ErrLineTooLong = errors.New("line too long")
+
+// Policy decides what a Reader does with a line longer than its max.
+type Policy int
+
+const (
+	// Disconnect returns ErrLineTooLong right away without consuming the
+	// rest of the line. The caller is expected to close the connection.
+	Disconnect Policy = iota
+	// Reject discards the whole line and returns ErrLineTooLong. The next
+	// read starts after the discarded line.
+	Reject
+	// Truncate returns the first max bytes of the line together with
+	// ErrLineTooLong and discards the rest.
+	Truncate
+)
+
+func (p Policy) String() string {
+	return [...]string{"disconnect", "reject", "truncate"}[p]
+}
+
+func ParsePolicy(s string) (Policy, error) {
+	switch strings.ToLower(s) {
+	case "disconnect":
+		return Disconnect, nil
+	case "reject":
+		return Reject, nil
+	case "truncate":
+		return Truncate, nil
+	default:
+		return Disconnect, fmt.Errorf("unknown line policy: %q", s)
+	}
+}
+
+func (p Policy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Policy) UnmarshalText(b []byte) error {
+	parsed, err := ParsePolicy(string(b))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// Reader reads '\n' terminated lines of at most max bytes, not counting
+// the newline, so a client that never sends one cannot make it buffer
+// without limit.
+type Reader struct {
+	bufr   *bufio.Reader
+	max    int
+	policy Policy
+}
+
+func NewReader(r io.Reader, max int, policy Policy) *Reader {
+	return &Reader{
+		bufr:   bufio.NewReader(r),
+		max:    max,
+		policy: policy,
+	}
+}
+
+func (r *Reader) Max() int {
+	return r.max
+}
+
+func (r *Reader) Policy() Policy {
+	return r.policy
+}
+
+// ReadBytes returns the next line including its newline. Like
+// bufio.Reader.ReadBytes, a final line without newline is returned
+// together with the read error.
+func (r *Reader) ReadBytes() ([]byte, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := r.bufr.ReadSlice('\n')
+		complete := err == nil
+		content := chunk
+		if complete {
+			content = chunk[:len(chunk)-1]
+		}
+
+		if !tooLong {
+			room := r.max - len(line)
+			if len(content) > room {
+				tooLong = true
+				switch r.policy {
+				case Disconnect:
+					return nil, ErrLineTooLong
+				case Truncate:
+					line = append(line, content[:room]...)
+				}
+			} else {
+				line = append(line, content...)
+			}
+		}
+
+		switch {
+		case complete && tooLong && r.policy == Reject:
+			return nil, ErrLineTooLong
+		case complete && tooLong:
+			return append(line, '\n'), ErrLineTooLong
+		case complete:
+			return append(line, '\n'), nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		default:
+			return line, err
+		}
+	}
+}
+
+// ReadLine is ReadBytes returning a string without the trailing newline.
+func (r *Reader) ReadLine() (string, error) {
+	line, err := r.ReadBytes()
+	return strings.TrimSuffix(string(line), "\n"), err
+}
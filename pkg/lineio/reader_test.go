@@ -0,0 +1,45 @@
+package lineio
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestPolicies tests every policy against the same oversized line.
+func TestPolicies(t *testing.T) {
+	input := "short\n" + strings.Repeat("x", 10000) + "\nnext\n"
+
+	reader := NewReader(strings.NewReader(input), 8, Reject)
+	expectLine(t, reader, "short", nil)
+	expectLine(t, reader, "", ErrLineTooLong)
+	expectLine(t, reader, "next", nil)
+
+	reader = NewReader(strings.NewReader(input), 8, Truncate)
+	expectLine(t, reader, "short", nil)
+	expectLine(t, reader, "xxxxxxxx", ErrLineTooLong)
+	expectLine(t, reader, "next", nil)
+
+	reader = NewReader(strings.NewReader(input), 8, Disconnect)
+	expectLine(t, reader, "short", nil)
+	expectLine(t, reader, "", ErrLineTooLong)
+}
+
+// TestExactFit tests that max does not count the newline.
+func TestExactFit(t *testing.T) {
+	reader := NewReader(strings.NewReader("12345678\n123"), 8, Disconnect)
+	expectLine(t, reader, "12345678", nil)
+	expectLine(t, reader, "123", io.EOF)
+}
+
+func expectLine(t *testing.T, reader *Reader, expected string, expectedErr error) {
+	t.Helper()
+	line, err := reader.ReadLine()
+	if !errors.Is(err, expectedErr) {
+		t.Fatalf("Expected error %v, got %v", expectedErr, err)
+	}
+	if line != expected {
+		t.Errorf("Expected %q, got %q", expected, line)
+	}
+}
@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	DefaultLevel  = "info"
+	DefaultFormat = "text"
+)
+
+type Options struct {
+	Level   string `json:"level"`
+	Format  string `json:"format"`
+	Verbose bool   `json:"-"`
+}
+
+// AddFlags registers the logging flags on the default flag set. The
+// returned options are filled in by flag.Parse.
+func AddFlags() *Options {
+	opts := &Options{}
+	flag.StringVar(&opts.Level, "log-level", DefaultLevel, "log level: debug, info, warn, error")
+	flag.StringVar(&opts.Format, "log-format", DefaultFormat, "log format: text or json")
+	flag.BoolVar(&opts.Verbose, "verbose", false, "verbose output, same as --log-level=debug")
+	return opts
+}
+
+// Setup installs the logger described by opts as the slog default.
+func Setup(opts Options) error {
+	logger, err := New(opts, os.Stderr)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+func New(opts Options, w io.Writer) (*slog.Logger, error) {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Verbose {
+		level = slog.LevelDebug
+	}
+	handlerOpts := &slog.HandlerOptions{Level: level}
+
+	switch strings.ToLower(opts.Format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, handlerOpts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, handlerOpts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format: %q", opts.Format)
+	}
+}
+
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("unknown log level: %q", s)
+	}
+	return level, nil
+}
+
+var (
+	// connPrefix tells connection IDs from different process runs apart.
+	connPrefix  = newConnPrefix()
+	connCounter atomic.Uint64
+)
+
+func newConnPrefix() string {
+	b := make([]byte, 2)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewConnID returns an ID unique to this process for an accepted connection.
+func NewConnID() string {
+	return fmt.Sprintf("%s-%06d", connPrefix, connCounter.Add(1))
+}
+
+// ForConn returns a logger annotated with the connection ID and remote
+// address so that one session can be filtered out of a busy log.
+func ForConn(logger *slog.Logger, connID string, remote string) *slog.Logger {
+	return logger.With("conn_id", connID, "remote", remote)
+}
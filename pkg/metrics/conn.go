@@ -0,0 +1,101 @@
+package metrics
+
+var (
+	acceptedVec = Default.Counter(
+		"protohackers_connections_accepted_total",
+		"Connections accepted.",
+		"handler",
+	)
+	refusedVec = Default.Counter(
+		"protohackers_connections_refused_total",
+		"Connections refused by connection limits.",
+		"handler", "reason",
+	)
+	activeVec = Default.Gauge(
+		"protohackers_connections_active",
+		"Connections currently open.",
+		"handler",
+	)
+	messagesReadVec = Default.Counter(
+		"protohackers_messages_read_total",
+		"Messages read from clients.",
+		"handler",
+	)
+	messagesWrittenVec = Default.Counter(
+		"protohackers_messages_written_total",
+		"Messages written to clients.",
+		"handler",
+	)
+	bytesInVec = Default.Counter(
+		"protohackers_bytes_in_total",
+		"Bytes read from clients.",
+		"handler",
+	)
+	bytesOutVec = Default.Counter(
+		"protohackers_bytes_out_total",
+		"Bytes written to clients.",
+		"handler",
+	)
+	errorsVec = Default.Counter(
+		"protohackers_handler_errors_total",
+		"Errors while reading, handling or writing messages.",
+		"handler",
+	)
+	oversizedVec = Default.Counter(
+		"protohackers_oversized_messages_total",
+		"Messages over the size limit of their protocol.",
+		"handler",
+	)
+	readTimeoutsVec = Default.Counter(
+		"protohackers_read_timeouts_total",
+		"Reads that hit the read deadline.",
+		"handler",
+	)
+)
+
+// ConnMetrics is the per-handler set of connection and traffic metrics.
+type ConnMetrics struct {
+	handler string
+
+	Accepted        *Counter
+	Active          *Gauge
+	MessagesRead    *Counter
+	MessagesWritten *Counter
+	BytesIn         *Counter
+	BytesOut        *Counter
+	Errors          *Counter
+	ReadTimeouts    *Counter
+	Oversized       *Counter
+}
+
+func ForHandler(handler string) *ConnMetrics {
+	return &ConnMetrics{
+		handler:         handler,
+		Accepted:        acceptedVec.With(handler),
+		Active:          activeVec.With(handler),
+		MessagesRead:    messagesReadVec.With(handler),
+		MessagesWritten: messagesWrittenVec.With(handler),
+		BytesIn:         bytesInVec.With(handler),
+		BytesOut:        bytesOutVec.With(handler),
+		Errors:          errorsVec.With(handler),
+		ReadTimeouts:    readTimeoutsVec.With(handler),
+		Oversized:       oversizedVec.With(handler),
+	}
+}
+
+// Refused records a connection refused for reason.
+func (cm *ConnMetrics) Refused(reason string) {
+	refusedVec.With(cm.handler, reason).Inc()
+}
+
+// Read records one inbound message of n bytes.
+func (cm *ConnMetrics) Read(n int) {
+	cm.MessagesRead.Inc()
+	cm.BytesIn.Add(n)
+}
+
+// Written records one outbound message of n bytes.
+func (cm *ConnMetrics) Written(n int) {
+	cm.MessagesWritten.Inc()
+	cm.BytesOut.Add(n)
+}
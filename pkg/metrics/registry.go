@@ -0,0 +1,209 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Default is the registry all servers report to and the one exposed by
+// Serve.
+var Default = NewRegistry()
+
+type metricKind string
+
+const (
+	counterKind metricKind = "counter"
+	gaugeKind   metricKind = "gauge"
+)
+
+// Registry keeps metric families and renders them in the Prometheus text
+// exposition format.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+type family struct {
+	name   string
+	help   string
+	kind   metricKind
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       atomic.Int64
+}
+
+func (r *Registry) register(name, help string, kind metricKind, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.kind != kind || !slices.Equal(f.labels, labels) {
+			panic(fmt.Sprintf("metric %s registered twice with different schema", name))
+		}
+		return f
+	}
+	f := &family{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*series),
+	}
+	r.families[name] = f
+	return f
+}
+
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: slices.Clone(values)}
+		f.series[key] = s
+	}
+	return s
+}
+
+type CounterVec struct {
+	f *family
+}
+
+// Counter registers (or returns the already registered) counter family.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{f: r.register(name, help, counterKind, labels)}
+}
+
+func (cv *CounterVec) With(labelValues ...string) *Counter {
+	return &Counter{s: cv.f.with(labelValues)}
+}
+
+type Counter struct {
+	s *series
+}
+
+func (c *Counter) Inc() {
+	c.s.value.Add(1)
+}
+
+func (c *Counter) Add(n int) {
+	if n < 0 {
+		panic("counter cannot decrease")
+	}
+	c.s.value.Add(int64(n))
+}
+
+func (c *Counter) Value() int64 {
+	return c.s.value.Load()
+}
+
+type GaugeVec struct {
+	f *family
+}
+
+// Gauge registers (or returns the already registered) gauge family.
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{f: r.register(name, help, gaugeKind, labels)}
+}
+
+func (gv *GaugeVec) With(labelValues ...string) *Gauge {
+	return &Gauge{s: gv.f.with(labelValues)}
+}
+
+type Gauge struct {
+	s *series
+}
+
+func (g *Gauge) Inc() {
+	g.s.value.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.s.value.Add(-1)
+}
+
+func (g *Gauge) Set(v int64) {
+	g.s.value.Store(v)
+}
+
+func (g *Gauge) Value() int64 {
+	return g.s.value.Load()
+}
+
+// WriteTo renders every family sorted by name, series sorted by labels.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	slices.SortFunc(families, func(a, b *family) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	sb := strings.Builder{}
+	for _, f := range families {
+		f.writeTo(&sb)
+	}
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+func (f *family) writeTo(sb *strings.Builder) {
+	f.mu.Lock()
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	f.mu.Unlock()
+	slices.SortFunc(all, func(a, b *series) int {
+		return slices.Compare(a.labelValues, b.labelValues)
+	})
+
+	fmt.Fprintf(sb, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(sb, "# TYPE %s %s\n", f.name, f.kind)
+	for _, s := range all {
+		sb.WriteString(f.name)
+		if len(f.labels) > 0 {
+			sb.WriteByte('{')
+			for i, label := range f.labels {
+				if i != 0 {
+					sb.WriteByte(',')
+				}
+				fmt.Fprintf(sb, "%s=\"%s\"", label, escapeLabel(s.labelValues[i]))
+			}
+			sb.WriteByte('}')
+		}
+		fmt.Fprintf(sb, " %d\n", s.value.Load())
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestWriteTo tests the text exposition of counters and gauges.
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("test_requests_total", "Requests seen.", "handler")
+	requests.With("echo").Add(3)
+	requests.With("chat").Inc()
+	active := r.Gauge("test_active", "Active things.")
+	active.With().Inc()
+	active.With().Inc()
+	active.With().Dec()
+
+	sb := strings.Builder{}
+	if _, err := r.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP test_active Active things.
+# TYPE test_active gauge
+test_active 1
+# HELP test_requests_total Requests seen.
+# TYPE test_requests_total counter
+test_requests_total{handler="chat"} 1
+test_requests_total{handler="echo"} 3
+`
+	if sb.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, sb.String())
+	}
+}
+
+// TestLabelEscaping tests that label values are escaped.
+func TestLabelEscaping(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("test_total", "Test.", "name").With("a\"b\\c\nd").Inc()
+
+	sb := strings.Builder{}
+	r.WriteTo(&sb)
+	if !strings.Contains(sb.String(), `test_total{name="a\"b\\c\nd"} 1`) {
+		t.Errorf("Label not escaped:\n%s", sb.String())
+	}
+}
@@ -1,13 +1,14 @@
 package mitm
 
 import (
-	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
+
+	"github.com/insomnes/protohackers/pkg/lineio"
 )
 
 type ConnError struct {
@@ -22,14 +23,18 @@ func (ce ConnError) Error() string {
 type MitmConn struct {
 	Address string
 	conn    net.Conn
+	reader  *lineio.Reader
+	logger  *slog.Logger
 
 	tx chan string
 }
 
-func NewMitmConn(conn net.Conn, addr string) MitmConn {
+func NewMitmConn(conn net.Conn, addr string, maxLine int, logger *slog.Logger) MitmConn {
 	return MitmConn{
 		Address: addr,
 		conn:    conn,
+		reader:  lineio.NewReader(conn, maxLine, lineio.Disconnect),
+		logger:  logger,
 		tx:      make(chan string, EventChannelSize),
 	}
 }
@@ -45,22 +50,23 @@ func (mc *MitmConn) QueueSend(message string) {
 
 func (mc *MitmConn) runRX(up chan<- string, fail chan<- ConnError) {
 	defer mc.conn.Close()
-	reader := bufio.NewReader(mc.conn)
 	for {
-		text, err := reader.ReadString('\n')
+		text, err := mc.reader.ReadBytes()
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
 				return
 			}
 			if errors.Is(err, io.EOF) {
-				log.Printf("Conn closed by %s\n", mc.Address)
+				mc.logger.Info("Conn closed by peer")
+			} else if errors.Is(err, lineio.ErrLineTooLong) {
+				mc.logger.Warn("Line too long, disconnecting", "max", mc.reader.Max())
 			} else {
-				log.Printf("Conn can not read from %s: %v\n", mc.Address, err)
+				mc.logger.Warn("Conn can not read", "err", err)
 			}
 			fail <- mc.NewError(err)
 			return
 		}
-		up <- text
+		up <- string(text)
 	}
 }
 
@@ -73,7 +79,7 @@ func (mc *MitmConn) runTX(ctx context.Context, fail chan<- ConnError) {
 		case text := <-mc.tx:
 			_, err := mc.conn.Write([]byte(text))
 			if err != nil {
-				log.Printf("Conn can not write to %s: %v\n", mc.Address, err)
+				mc.logger.Warn("Conn can not write", "err", err)
 				fail <- mc.NewError(err)
 				return
 			}
@@ -4,10 +4,14 @@ import (
 	"context"
 	"errors"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"regexp"
 	"strings"
+
+	"github.com/insomnes/protohackers/pkg/lineio"
+	"github.com/insomnes/protohackers/pkg/logging"
+	"github.com/insomnes/protohackers/pkg/metrics"
 )
 
 const (
@@ -15,7 +19,10 @@ const (
 	walletPattern string = `^(7[a-zA-Z0-9]{25,34})$`
 )
 
-var re *regexp.Regexp = regexp.MustCompile(walletPattern)
+var (
+	re          *regexp.Regexp = regexp.MustCompile(walletPattern)
+	mitmMetrics                = metrics.ForHandler("mitm")
+)
 
 func tonyWalletFix(text string) string {
 	sSplit := strings.Split(text[:len(text)-1], " ")
@@ -34,18 +41,28 @@ func tonyWalletFix(text string) string {
 	return builder.String()
 }
 
-func RunMitmProxy(ctx context.Context, conn net.Conn, chatAddr string) {
+func RunMitmProxy(ctx context.Context, conn net.Conn, chatAddr string, maxLine int) {
+	mitmMetrics.Accepted.Inc()
+	mitmMetrics.Active.Inc()
+	defer mitmMetrics.Active.Dec()
 	userCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	defer conn.Close()
-	defer log.Printf("Mitm proxy for %s closed", conn.RemoteAddr().String())
 
-	userConn := NewMitmConn(conn, conn.RemoteAddr().String())
+	logger := logging.ForConn(
+		slog.Default().With("handler", "mitm"),
+		logging.NewConnID(),
+		conn.RemoteAddr().String(),
+	)
+	defer logger.Info("Mitm proxy closed")
+
+	userConn := NewMitmConn(conn, conn.RemoteAddr().String(), maxLine, logger.With("side", "client"))
 	userUp := make(chan string, EventChannelSize)
 
-	chatConn, err := createChatServerConn(chatAddr)
+	chatConn, err := createChatServerConn(chatAddr, maxLine, logger.With("side", "upstream"))
 	if err != nil {
-		log.Printf("Failed to create chat connection for %s: %v", userConn.Address, err)
+		mitmMetrics.Errors.Inc()
+		logger.Error("Failed to create chat connection", "err", err)
 		return
 	}
 	chatUp := make(chan string, EventChannelSize)
@@ -55,32 +72,38 @@ func RunMitmProxy(ctx context.Context, conn net.Conn, chatAddr string) {
 	userConn.Run(userCtx, userUp, fail)
 	chatConn.Run(userCtx, chatUp, fail)
 
-	log.Printf("Mitm proxy for %s<->%s started", userConn.Address, chatConn.Address)
+	logger.Info("Mitm proxy started", "upstream", chatConn.Address)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case userMessage := <-userUp:
-			chatConn.QueueSend(tonyWalletFix(userMessage))
+			relay(&chatConn, userMessage)
 		case chatMessage := <-chatUp:
-			userConn.QueueSend(tonyWalletFix(chatMessage))
+			relay(&userConn, chatMessage)
 		case err := <-fail:
 			if errors.Is(err.Err, net.ErrClosed) || errors.Is(err.Err, io.EOF) {
 				return
 			}
-			log.Printf(
-				"Err on conn for pair %s<->%s => %v",
-				userConn.Address,
-				chatConn.Address,
-				err,
-			)
+			if errors.Is(err.Err, lineio.ErrLineTooLong) {
+				mitmMetrics.Oversized.Inc()
+			}
+			mitmMetrics.Errors.Inc()
+			logger.Error("Err on conn pair", "upstream", chatConn.Address, "err", err)
 			return
 		}
 	}
 }
 
-func createChatServerConn(chatAddr string) (MitmConn, error) {
+func relay(to *MitmConn, message string) {
+	mitmMetrics.Read(len(message))
+	fixed := tonyWalletFix(message)
+	to.QueueSend(fixed)
+	mitmMetrics.Written(len(fixed))
+}
+
+func createChatServerConn(chatAddr string, maxLine int, logger *slog.Logger) (MitmConn, error) {
 	srvTCPAddr, err := net.ResolveTCPAddr("tcp", chatAddr)
 	if err != nil {
 		return MitmConn{}, err
@@ -91,5 +114,5 @@ func createChatServerConn(chatAddr string) (MitmConn, error) {
 		return MitmConn{}, err
 	}
 
-	return NewMitmConn(conn, conn.LocalAddr().String()), nil
+	return NewMitmConn(conn, conn.LocalAddr().String(), maxLine, logger), nil
 }
@@ -2,55 +2,87 @@ package mitm
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
 	"net"
-	"os"
-	"os/signal"
-	"syscall"
 	"time"
+
+	"github.com/insomnes/protohackers/pkg/limiter"
+)
+
+const (
+	EventChannelSize = 16
+	DefaultChatAddr  = "chat.protohackers.com:16963"
+	// DefaultMaxLineSize is generous so the proxy is never stricter than
+	// the chat server behind it.
+	DefaultMaxLineSize = 64 * 1024
 )
 
-const EventChannelSize = 16
+type Config struct {
+	Address  string         `json:"-"`
+	ChatAddr string         `json:"chat"`
+	Limits   limiter.Config `json:"limits"`
+	// MaxLineSize in bytes; peers sending longer lines are disconnected.
+	MaxLineSize int `json:"max_line_size"`
+}
 
 type MitmServer struct {
-	Address  string
-	ChatAddr string
+	Config
 }
 
-func NewMitmServer(address string, chatAddr string) MitmServer {
+func NewMitmServer(cfg Config) MitmServer {
+	if cfg.MaxLineSize <= 0 {
+		cfg.MaxLineSize = DefaultMaxLineSize
+	}
 	return MitmServer{
-		Address:  address,
-		ChatAddr: chatAddr,
+		Config: cfg,
 	}
 }
 
-func (ms *MitmServer) Run() {
+func (ms *MitmServer) Run(ctx context.Context) error {
 	ln, err := net.Listen("tcp", ms.Address)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to listen: %w", err)
 	}
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	connLimiter := limiter.New(ms.Limits)
 
+	acceptErr := make(chan error, 1)
 	go func() {
-		defer ln.Close()
-
 		for {
 			conn, err := ln.Accept()
 			if err != nil {
-				log.Println("Error accepting connection:", err)
+				acceptErr <- err
 				return
 			}
-			log.Printf("Connection from %s\n", conn.RemoteAddr())
-			go RunMitmProxy(ctx, conn, ms.ChatAddr)
+			limited, err := connLimiter.Accept(conn)
+			if err != nil {
+				mitmMetrics.Refused(limiter.Reason(err))
+				refuse(conn, err)
+				continue
+			}
+			conn = limited
+			slog.Debug("Connection accepted", "remote", conn.RemoteAddr().String())
+			go RunMitmProxy(ctx, conn, ms.ChatAddr, ms.MaxLineSize)
 		}
 	}()
 
-	sig := <-sigChan
-	log.Printf("Signal received: %v\n", sig)
-	cancel()
-	<-time.After(300 * time.Millisecond)
+	select {
+	case <-ctx.Done():
+		ln.Close()
+		<-time.After(300 * time.Millisecond)
+		return nil
+	case err := <-acceptErr:
+		ln.Close()
+		cancel()
+		return fmt.Errorf("failed to accept: %w", err)
+	}
+}
+
+// refuse answers in chat protocol terms since mitm clients expect a chat.
+func refuse(conn net.Conn, reason error) {
+	slog.Warn("Refusing connection", "remote", conn.RemoteAddr().String(), "reason", reason)
+	limiter.Refuse(conn, []byte(fmt.Sprintf("* Server is busy (%v), try again later\n", reason)))
 }
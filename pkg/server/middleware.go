@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"runtime/debug"
+
+	"github.com/insomnes/protohackers/pkg/config"
+	"github.com/insomnes/protohackers/pkg/metrics"
+)
+
+// ErrMessageTooLarge is matched by every oversized message error, whether
+// returned by a MsgReader or by the MaxMessageSize middleware.
+var ErrMessageTooLarge = errors.New("message too large")
+
+// MsgHandlerFunc adapts a plain function to MsgHandler.
+type MsgHandlerFunc func(msg []byte) ([]byte, error)
+
+func (f MsgHandlerFunc) HandleMessage(msg []byte) ([]byte, error) {
+	return f(msg)
+}
+
+// Middleware wraps the MsgHandler of a single connection.
+type Middleware func(next MsgHandler, conn net.Conn, logger *slog.Logger) MsgHandler
+
+// Chain returns a ConnHandler whose message handlers are wrapped by
+// middlewares, the first one being the outermost.
+func Chain(handler ConnHandler, middlewares ...Middleware) ConnHandler {
+	return &chain{ConnHandler: handler, middlewares: middlewares}
+}
+
+// DefaultMiddlewares is the chain every protohackers handler runs with.
+// Recover is innermost so the others see panics as errors.
+func DefaultMiddlewares(cfg config.ServerConfig) []Middleware {
+	middlewares := []Middleware{Logging()}
+	if cfg.MaxMessageSize > 0 {
+		middlewares = append(middlewares, MaxMessageSize(cfg.MaxMessageSize))
+	}
+	return append(middlewares, Recover())
+}
+
+type chain struct {
+	ConnHandler
+	middlewares []Middleware
+}
+
+func (c *chain) GetMsgHandler(conn net.Conn, logger *slog.Logger) MsgHandler {
+	handler := c.ConnHandler.GetMsgHandler(conn, logger)
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		handler = c.middlewares[i](handler, conn, logger)
+	}
+	return handler
+}
+
+func (c *chain) RefuseMessage(err error) []byte {
+	if refuser, ok := c.ConnHandler.(Refuser); ok {
+		return refuser.RefuseMessage(err)
+	}
+	return nil
+}
+
+// PanicError is a handler panic turned into an error, carrying the stack
+// of the panic for the log.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in handler: %v", e.Value)
+}
+
+// recoverPanic stores a panic of the calling function in err. It must be
+// deferred directly.
+func recoverPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = &PanicError{Value: r, Stack: debug.Stack()}
+	}
+}
+
+// logError logs err once, with the stack if it was a panic.
+func logError(logger *slog.Logger, msg string, err error) {
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		logger.Error(msg, "err", err, "stack", string(panicErr.Stack))
+		return
+	}
+	logger.Error(msg, "err", err)
+}
+
+// Recover turns a panic in the wrapped handler into a PanicError, which
+// closes the connection instead of crashing the process. The server
+// recovers every handler the same way, so this only matters to handlers
+// used outside of it.
+func Recover() Middleware {
+	return func(next MsgHandler, conn net.Conn, logger *slog.Logger) MsgHandler {
+		return MsgHandlerFunc(func(msg []byte) (resp []byte, err error) {
+			defer recoverPanic(&err)
+			return next.HandleMessage(msg)
+		})
+	}
+}
+
+// Logging logs every message and response at debug level. Errors are
+// logged and counted by the server.
+func Logging() Middleware {
+	return func(next MsgHandler, conn net.Conn, logger *slog.Logger) MsgHandler {
+		return MsgHandlerFunc(func(msg []byte) ([]byte, error) {
+			logger.Debug("Message received", "size", len(msg), "msg", msg)
+			resp, err := next.HandleMessage(msg)
+			if err == nil && resp != nil {
+				logger.Debug("Response", "size", len(resp), "resp", resp)
+			}
+			return resp, err
+		})
+	}
+}
+
+// MaxMessageSize rejects messages longer than n bytes, closing the
+// connection.
+func MaxMessageSize(n int) Middleware {
+	return func(next MsgHandler, conn net.Conn, logger *slog.Logger) MsgHandler {
+		return MsgHandlerFunc(func(msg []byte) ([]byte, error) {
+			if len(msg) > n {
+				return nil, fmt.Errorf("%w: %d > %d bytes", ErrMessageTooLarge, len(msg), n)
+			}
+			return next.HandleMessage(msg)
+		})
+	}
+}
+
+// Metrics counts messages, responses and errors of the wrapped handler.
+// Server counts them itself, so this is for handlers served without it.
+func Metrics(cm *metrics.ConnMetrics) Middleware {
+	return func(next MsgHandler, conn net.Conn, logger *slog.Logger) MsgHandler {
+		return MsgHandlerFunc(func(msg []byte) ([]byte, error) {
+			cm.Read(len(msg))
+			resp, err := next.HandleMessage(msg)
+			switch {
+			case errors.Is(err, ErrMessageTooLarge):
+				cm.Oversized.Inc()
+			case err != nil:
+				cm.Errors.Inc()
+			case resp != nil:
+				cm.Written(len(resp))
+			}
+			return resp, err
+		})
+	}
+}
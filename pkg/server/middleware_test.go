@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/insomnes/protohackers/pkg/metrics"
+)
+
+func tagging(tag string, calls *[]string) Middleware {
+	return func(next MsgHandler, conn net.Conn, logger *slog.Logger) MsgHandler {
+		return MsgHandlerFunc(func(msg []byte) ([]byte, error) {
+			*calls = append(*calls, tag)
+			return next.HandleMessage(msg)
+		})
+	}
+}
+
+type panicHandler struct {
+	lineHandler
+}
+
+func (ph *panicHandler) GetMsgHandler(conn net.Conn, logger *slog.Logger) MsgHandler {
+	return MsgHandlerFunc(func(msg []byte) ([]byte, error) {
+		panic("boom")
+	})
+}
+
+// TestChainOrder tests that the first middleware is the outermost.
+func TestChainOrder(t *testing.T) {
+	calls := []string{}
+	handler := Chain(&lineHandler{}, tagging("a", &calls), tagging("b", &calls))
+	msgHandler := handler.GetMsgHandler(nil, slog.Default())
+
+	resp, err := msgHandler.HandleMessage([]byte("hi"))
+	if err != nil || string(resp) != "hi" {
+		t.Fatalf("Expected echo, got %q (%v)", resp, err)
+	}
+	if !slices.Equal(calls, []string{"a", "b"}) {
+		t.Errorf("Expected [a b], got %v", calls)
+	}
+}
+
+// TestRecover tests that a panicking handler returns an error, whether
+// wrapped by Recover or run by the server as a stream handler.
+func TestRecover(t *testing.T) {
+	handler := Chain(&panicHandler{}, Logging(), Recover())
+	msgHandler := handler.GetMsgHandler(nil, slog.Default())
+
+	var panicErr *PanicError
+	if _, err := msgHandler.HandleMessage([]byte("hi")); !errors.As(err, &panicErr) {
+		t.Errorf("Expected PanicError from recovered panic, got %v", err)
+	}
+
+	stream := Adapt(&panicHandler{}).GetStreamHandler(nil, slog.Default())
+	if err := handle(context.Background(), stream, []byte("hi"), nil); !errors.As(err, &panicErr) {
+		t.Errorf("Expected PanicError from stream handler, got %v", err)
+	}
+	if panicErr == nil || len(panicErr.Stack) == 0 {
+		t.Errorf("Expected the panic stack to be kept")
+	}
+}
+
+// TestMaxMessageSize tests that oversized messages are rejected.
+func TestMaxMessageSize(t *testing.T) {
+	handler := Chain(&lineHandler{}, MaxMessageSize(3))
+	msgHandler := handler.GetMsgHandler(nil, slog.Default())
+
+	if _, err := msgHandler.HandleMessage([]byte("abc")); err != nil {
+		t.Errorf("Expected 3 bytes to pass, got %v", err)
+	}
+	if _, err := msgHandler.HandleMessage([]byte("abcd")); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Expected ErrMessageTooLarge, got %v", err)
+	}
+}
+
+// TestMetrics tests that messages, responses and errors are counted.
+func TestMetrics(t *testing.T) {
+	cm := metrics.ForHandler("middleware_test")
+	handler := Chain(&lineHandler{}, Metrics(cm), MaxMessageSize(3))
+	msgHandler := handler.GetMsgHandler(nil, slog.Default())
+
+	msgHandler.HandleMessage([]byte("abc"))
+	msgHandler.HandleMessage([]byte("abcd"))
+	counts := []struct {
+		name    string
+		counter *metrics.Counter
+		want    int64
+	}{
+		{"messages read", cm.MessagesRead, 2},
+		{"bytes in", cm.BytesIn, 7},
+		{"messages written", cm.MessagesWritten, 1},
+		{"bytes out", cm.BytesOut, 3},
+		{"oversized", cm.Oversized, 1},
+		{"errors", cm.Errors, 0},
+	}
+	for _, c := range counts {
+		if got := c.counter.Value(); got != c.want {
+			t.Errorf("Expected %d %s, got %d", c.want, c.name, got)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/insomnes/protohackers/pkg/config"
+)
+
+// maxDatagramSize is the largest possible UDP payload.
+const maxDatagramSize = 65535
+
+// PacketHandler is the datagram counterpart of ConnHandler.
+type PacketHandler interface {
+	// HandlePacket handles one datagram from addr and returns the replies
+	// to send back to it, in order.
+	HandlePacket(payload []byte, addr net.Addr, logger *slog.Logger) ([][]byte, error)
+}
+
+// NewPacketServer returns a Server listening on UDP and passing every
+// datagram to handler.
+func NewPacketServer(
+	cfg config.ServerConfig,
+	handler PacketHandler,
+) *Server {
+	cfg.Network = config.NetworkUDP
+	s := NewServer(cfg, nil)
+	s.packetHandler = handler
+	return s
+}
+
+// runPacket reads datagrams until ctx is cancelled. Datagrams are handled
+// one at a time in arrival order.
+func (s *Server) runPacket(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	defer conn.Close()
+
+	s.logger.Info("Running packet server", "addr", s.addr)
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stopped:
+		}
+	}()
+
+	bufSize := maxDatagramSize
+	if s.MaxMessageSize > 0 {
+		// One extra byte tells oversized datagrams apart from exact fits.
+		bufSize = min(s.MaxMessageSize+1, maxDatagramSize)
+	}
+	buf := make([]byte, bufSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil && errors.Is(err, net.ErrClosed) {
+				s.logger.Info("Packet server stopped")
+				return nil
+			}
+			return fmt.Errorf("failed to read: %w", err)
+		}
+		s.handlePacket(conn, bytes.Clone(buf[:n]), addr)
+	}
+}
+
+func (s *Server) handlePacket(conn net.PacketConn, payload []byte, addr net.Addr) {
+	logger := s.logger.With("remote", addr.String())
+	s.metrics.Read(len(payload))
+	logger.Debug("Packet received", "size", len(payload), "msg", payload)
+
+	if s.MaxMessageSize > 0 && len(payload) > s.MaxMessageSize {
+		s.metrics.Errors.Inc()
+		logger.Warn("Dropping oversized packet", "max", s.MaxMessageSize)
+		return
+	}
+
+	replies, err := s.callPacketHandler(payload, addr, logger)
+	if err != nil {
+		s.metrics.Errors.Inc()
+		logError(logger, "Error handling packet", err)
+		return
+	}
+	for _, reply := range replies {
+		conn.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
+		if _, err := conn.WriteTo(reply, addr); err != nil {
+			s.metrics.Errors.Inc()
+			logger.Error("Error writing packet", "err", err)
+			return
+		}
+		s.metrics.Written(len(reply))
+		logger.Debug("Reply sent", "size", len(reply), "resp", reply)
+	}
+}
+
+func (s *Server) callPacketHandler(
+	payload []byte,
+	addr net.Addr,
+	logger *slog.Logger,
+) (replies [][]byte, err error) {
+	defer recoverPanic(&err)
+	return s.packetHandler.HandlePacket(payload, addr, logger)
+}
@@ -1,16 +1,24 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
-	"os"
+	"sync"
 	"time"
 
 	"github.com/insomnes/protohackers/pkg/config"
+	"github.com/insomnes/protohackers/pkg/limiter"
+	"github.com/insomnes/protohackers/pkg/logging"
+	"github.com/insomnes/protohackers/pkg/metrics"
 )
 
 type ConnHandler interface {
-	GetMsgHandler(conn net.Conn, verbose bool) MsgHandler
+	// GetMsgHandler is called once per connection; logger carries the
+	// connection ID and remote address.
+	GetMsgHandler(conn net.Conn, logger *slog.Logger) MsgHandler
 	GetReader(conn net.Conn) MsgReader
 }
 
@@ -22,77 +30,237 @@ type MsgReader interface {
 	ReadMessage() ([]byte, error)
 }
 
+// Refuser is optionally implemented by a ConnHandler to tell clients
+// refused by connection limits why, in the protocol's own terms.
+type Refuser interface {
+	RefuseMessage(err error) []byte
+}
+
 type Server struct {
 	config.ServerConfig
-	addr    string
-	handler ConnHandler
+	addr          string
+	handler       StreamConnHandler
+	packetHandler PacketHandler
+	metrics       *metrics.ConnMetrics
+	logger        *slog.Logger
+	limiter       *limiter.Limiter
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
 }
 
 func NewServer(
 	cfg config.ServerConfig,
 	handler ConnHandler,
-) Server {
+) *Server {
+	return NewStreamServer(cfg, Adapt(handler))
+}
+
+func NewStreamServer(
+	cfg config.ServerConfig,
+	handler StreamConnHandler,
+) *Server {
 	addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
-	return Server{
+	if cfg.Network == "" {
+		cfg.Network = config.NetworkTCP
+	}
+	return &Server{
 		ServerConfig: cfg,
 		addr:         addr,
 		handler:      handler,
+		metrics:      metrics.ForHandler(cfg.Handler),
+		logger:       slog.Default().With("handler", cfg.Handler),
+		limiter:      limiter.New(cfg.Limits),
+		conns:        make(map[net.Conn]struct{}),
 	}
 }
 
-func (s *Server) Run() error {
-	serverAddr := fmt.Sprintf("%s:%d", s.Host, s.Port)
-	listener, err := net.Listen("tcp", serverAddr)
+// Run accepts connections until ctx is cancelled, then stops accepting
+// and drains active connections for up to ShutdownTimeout.
+func (s *Server) Run(ctx context.Context) error {
+	if s.Network == config.NetworkUDP {
+		return s.runPacket(ctx)
+	}
+
+	listener, err := net.Listen("tcp", s.addr)
 	if err != nil {
 		err := fmt.Errorf("failed to listen: %w", err)
 		return err
 	}
 
-	fmt.Println("Running server on", serverAddr)
-	defer listener.Close()
+	s.logger.Info("Running server", "addr", s.addr)
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			listener.Close()
+		case <-stopped:
+		}
+	}()
+
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if ctx.Err() != nil && errors.Is(err, net.ErrClosed) {
+				s.shutdown()
+				return nil
+			}
+			listener.Close()
+			s.shutdown()
 			err := fmt.Errorf("failed to accept: %w", err)
 			return err
 		}
 
-		go s.handleConnection(conn)
+		limited, err := s.limiter.Accept(conn)
+		if err != nil {
+			s.refuse(conn, err)
+			continue
+		}
+		conn = limited
+
+		s.metrics.Accepted.Inc()
+		s.track(conn)
+		go func() {
+			defer s.untrack(conn)
+			s.handleConnection(ctx, conn)
+		}()
 	}
 }
 
-func (s *Server) handleConnection(conn net.Conn) {
-	remote := conn.RemoteAddr().String()
-	fmt.Println("Handling connection from", remote)
+func (s *Server) refuse(conn net.Conn, reason error) {
+	s.metrics.Refused(limiter.Reason(reason))
+	s.logger.Warn("Refusing connection", "remote", conn.RemoteAddr().String(), "reason", reason)
+
+	var msg []byte
+	if refuser, ok := s.handler.(Refuser); ok {
+		msg = refuser.RefuseMessage(reason)
+	}
+	limiter.Refuse(conn, msg)
+}
+
+func (s *Server) track(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	s.metrics.Active.Inc()
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+	s.wg.Done()
+	s.metrics.Active.Dec()
+}
+
+func (s *Server) activeConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// shutdown waits for active handlers to finish and force-closes the ones
+// still running when ShutdownTimeout expires.
+func (s *Server) shutdown() {
+	active := s.activeConns()
+	s.logger.Info("Shutting down, draining connections", "active", active)
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.logger.Info("Shutdown complete", "drained", active, "aborted", 0)
+		return
+	case <-time.After(s.ShutdownTimeout):
+	}
+
+	s.mu.Lock()
+	aborted := len(s.conns)
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	<-done
+
+	s.logger.Info("Shutdown complete", "drained", active-aborted, "aborted", aborted)
+}
+
+func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
+	logger := logging.ForConn(s.logger, logging.NewConnID(), conn.RemoteAddr().String())
+	logger.Info("Handling connection")
 	defer conn.Close()
 
+	connCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	out := newConnSender(conn, s.WriteTimeout, s.metrics)
 	reader := s.handler.GetReader(conn)
-	handler := s.handler.GetMsgHandler(conn, s.Verbose)
+	handler := s.handler.GetStreamHandler(conn, logger)
+	if starter, ok := handler.(Starter); ok {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := start(connCtx, starter, out); err != nil {
+				s.metrics.Errors.Inc()
+				logError(logger, "Error in handler", err)
+				conn.Close()
+			}
+		}()
+	}
+
 	for {
 		conn.SetReadDeadline(time.Now().Add(s.ReadTimeout))
 		msg, err := reader.ReadMessage()
 		if err != nil {
-			switch err.Error() {
-			case "EOF":
-				fmt.Println("Connection closed by client")
+			var netErr net.Error
+			switch {
+			case err.Error() == "EOF":
+				logger.Info("Connection closed by client")
+			case errors.Is(err, ErrMessageTooLarge):
+				s.metrics.Oversized.Inc()
+				logger.Warn("Message too large", "err", err)
+			case errors.As(err, &netErr) && netErr.Timeout():
+				s.metrics.ReadTimeouts.Inc()
+				logger.Warn("Read timeout")
 			default:
-				fmt.Fprintln(os.Stderr, "Error reading from conn:", err)
+				s.metrics.Errors.Inc()
+				logger.Error("Error reading from conn", "err", err)
 			}
 			return
 		}
-		resp, err := handler.HandleMessage(msg)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error handling message:", err)
+		s.metrics.Read(len(msg))
+		if err := handle(connCtx, handler, msg, out); err != nil {
+			s.metrics.Errors.Inc()
+			logError(logger, "Error handling message", err)
 			return
 		}
-		if resp == nil {
-			continue
-		}
 
-		conn.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
-		if _, err := conn.Write(resp); err != nil {
-			fmt.Fprintln(os.Stderr, "Error writing to conn:", err)
+		if ctx.Err() != nil {
+			logger.Info("Closing connection on shutdown")
 			return
 		}
 	}
 }
+
+// handle passes msg to handler, turning a panic into an error so one
+// connection cannot crash the process.
+func handle(ctx context.Context, handler StreamHandler, msg []byte, out Sender) (err error) {
+	defer recoverPanic(&err)
+	return handler.HandleMessage(ctx, msg, out)
+}
+
+// start runs starter, turning a panic into an error like handle.
+func start(ctx context.Context, starter Starter, out Sender) (err error) {
+	defer recoverPanic(&err)
+	starter.Start(ctx, out)
+	return nil
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomnes/protohackers/pkg/config"
+)
+
+type lineHandler struct{}
+
+func (lh *lineHandler) GetReader(conn net.Conn) MsgReader {
+	return &lineReader{bufr: bufio.NewReader(conn)}
+}
+
+func (lh *lineHandler) GetMsgHandler(conn net.Conn, logger *slog.Logger) MsgHandler {
+	return &echoMsgHandler{}
+}
+
+type lineReader struct {
+	bufr *bufio.Reader
+}
+
+func (lr *lineReader) ReadMessage() ([]byte, error) {
+	return lr.bufr.ReadBytes('\n')
+}
+
+type echoMsgHandler struct{}
+
+func (em *echoMsgHandler) HandleMessage(msg []byte) ([]byte, error) {
+	return msg, nil
+}
+
+func testConfig() config.ServerConfig {
+	return config.ServerConfig{
+		Host:            "127.0.0.1",
+		Port:            0,
+		ReadTimeout:     5 * time.Second,
+		WriteTimeout:    5 * time.Second,
+		ShutdownTimeout: 200 * time.Millisecond,
+	}
+}
+
+// TestShutdownAbortsIdleConnections checks that Run returns after the
+// shutdown timeout even when a client never sends anything.
+func TestShutdownAbortsIdleConnections(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	srv := NewServer(testConfig(), &lineHandler{})
+	srv.addr = addr
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- srv.Run(ctx) }()
+
+	var conn net.Conn
+	for range 50 {
+		conn, err = net.Dial("tcp", addr)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || resp != "hello\n" {
+		t.Fatalf("Expected echo, got %q (%v)", resp, err)
+	}
+
+	cancel()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("Expected clean shutdown, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Server did not stop after shutdown timeout")
+	}
+	if active := srv.activeConns(); active != 0 {
+		t.Errorf("Expected 0 active connections, got %d", active)
+	}
+}
+
+type upperPacketHandler struct{}
+
+func (uh *upperPacketHandler) HandlePacket(payload []byte, addr net.Addr, logger *slog.Logger) ([][]byte, error) {
+	if len(payload) == 0 {
+		return nil, nil
+	}
+	return [][]byte{bytes.ToUpper(payload), payload}, nil
+}
+
+// TestPacketServer tests that every reply is sent back to the sender.
+func TestPacketServer(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := pc.LocalAddr().String()
+	pc.Close()
+
+	srv := NewPacketServer(testConfig(), &upperPacketHandler{})
+	srv.addr = addr
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.Run(ctx)
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	buf := make([]byte, 64)
+	var n int
+	for range 50 {
+		conn.Write([]byte("abc"))
+		conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		n, err = conn.Read(buf)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "ABC" {
+		t.Errorf("Expected ABC, got %q", buf[:n])
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err = conn.Read(buf)
+	if err != nil || string(buf[:n]) != "abc" {
+		t.Errorf("Expected second reply abc, got %q (%v)", buf[:n], err)
+	}
+}
+
+type doubleStreamHandler struct {
+	lineHandler
+}
+
+func (dh *doubleStreamHandler) GetStreamHandler(conn net.Conn, logger *slog.Logger) StreamHandler {
+	return dh
+}
+
+func (dh *doubleStreamHandler) Start(ctx context.Context, out Sender) {
+	out.Send([]byte("hello\n"))
+}
+
+func (dh *doubleStreamHandler) HandleMessage(ctx context.Context, msg []byte, out Sender) error {
+	if err := out.Send(msg); err != nil {
+		return err
+	}
+	return out.Send(msg)
+}
+
+// TestStreamServer tests unsolicited and multiple responses.
+func TestStreamServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	srv := NewStreamServer(testConfig(), &doubleStreamHandler{})
+	srv.addr = addr
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.Run(ctx)
+
+	var conn net.Conn
+	for range 50 {
+		conn, err = net.Dial("tcp", addr)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	if line, err := reader.ReadString('\n'); err != nil || line != "hello\n" {
+		t.Fatalf("Expected greeting, got %q (%v)", line, err)
+	}
+	conn.Write([]byte("ping\n"))
+	for range 2 {
+		if line, err := reader.ReadString('\n'); err != nil || line != "ping\n" {
+			t.Fatalf("Expected ping, got %q (%v)", line, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/insomnes/protohackers/pkg/metrics"
+)
+
+// Sender writes messages to the client of one connection. It is safe for
+// concurrent use, so handlers may push data while reads keep going.
+type Sender interface {
+	Send(msg []byte) error
+}
+
+// StreamConnHandler is the streaming counterpart of ConnHandler: its
+// handlers may answer a message with any number of messages, or send data
+// nobody asked for.
+type StreamConnHandler interface {
+	// GetStreamHandler is called once per connection; logger carries the
+	// connection ID and remote address.
+	GetStreamHandler(conn net.Conn, logger *slog.Logger) StreamHandler
+	GetReader(conn net.Conn) MsgReader
+}
+
+type StreamHandler interface {
+	// HandleMessage is called for every message read from the connection.
+	// ctx is cancelled when the connection closes or the server stops.
+	HandleMessage(ctx context.Context, msg []byte, out Sender) error
+}
+
+// Starter is optionally implemented by a StreamHandler that pushes data on
+// its own (heartbeats, broadcasts). Start runs alongside the reads for the
+// lifetime of the connection and must return once ctx is done.
+type Starter interface {
+	Start(ctx context.Context, out Sender)
+}
+
+// Adapt runs a request/response ConnHandler as a StreamConnHandler, sending
+// the response of every message, if any.
+func Adapt(handler ConnHandler) StreamConnHandler {
+	return &msgAdapter{ConnHandler: handler}
+}
+
+type msgAdapter struct {
+	ConnHandler
+}
+
+func (ma *msgAdapter) GetStreamHandler(conn net.Conn, logger *slog.Logger) StreamHandler {
+	return &msgStream{handler: ma.GetMsgHandler(conn, logger)}
+}
+
+func (ma *msgAdapter) RefuseMessage(err error) []byte {
+	if refuser, ok := ma.ConnHandler.(Refuser); ok {
+		return refuser.RefuseMessage(err)
+	}
+	return nil
+}
+
+type msgStream struct {
+	handler MsgHandler
+}
+
+func (ms *msgStream) HandleMessage(ctx context.Context, msg []byte, out Sender) error {
+	resp, err := ms.handler.HandleMessage(msg)
+	if err != nil {
+		return err
+	}
+	if resp == nil {
+		return nil
+	}
+	return out.Send(resp)
+}
+
+// connSender serializes writes to a connection. After the first failed
+// write the connection is closed and every later Send fails. The error
+// is counted and logged by whoever gets it back, not here.
+type connSender struct {
+	conn    net.Conn
+	timeout time.Duration
+	metrics *metrics.ConnMetrics
+
+	mu  sync.Mutex
+	err error
+}
+
+func newConnSender(conn net.Conn, timeout time.Duration, connMetrics *metrics.ConnMetrics) *connSender {
+	return &connSender{
+		conn:    conn,
+		timeout: timeout,
+		metrics: connMetrics,
+	}
+}
+
+func (cs *connSender) Send(msg []byte) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.err != nil {
+		return cs.err
+	}
+
+	cs.conn.SetWriteDeadline(time.Now().Add(cs.timeout))
+	if _, err := cs.conn.Write(msg); err != nil {
+		cs.err = fmt.Errorf("failed to write: %w", err)
+		cs.conn.Close()
+		return cs.err
+	}
+	cs.metrics.Written(len(msg))
+	return nil
+}
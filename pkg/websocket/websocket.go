@@ -0,0 +1,372 @@
+// Package websocket implements the server side of RFC 6455: the opening
+// handshake on top of net/http and message framing on the hijacked
+// connection.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// acceptGUID is the fixed suffix of the Sec-WebSocket-Accept hash.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const DefaultMaxMessageSize = 64 << 10
+
+// closeTimeout bounds the write of a close frame.
+const closeTimeout = time.Second
+
+type Opcode byte
+
+const (
+	OpContinuation Opcode = 0x0
+	OpText         Opcode = 0x1
+	OpBinary       Opcode = 0x2
+	OpClose        Opcode = 0x8
+	OpPing         Opcode = 0x9
+	OpPong         Opcode = 0xA
+)
+
+func (op Opcode) isControl() bool {
+	return op&0x8 != 0
+}
+
+// Close status codes used by this package.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseInvalidPayload  = 1007
+	CloseMessageTooBig   = 1009
+)
+
+var (
+	ErrMessageTooLarge = errors.New("websocket: message too large")
+	ErrProtocol        = errors.New("websocket: protocol error")
+)
+
+// CloseError is returned by ReadMessage once the peer closed the
+// connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed by peer (%d %s)", e.Code, e.Reason)
+}
+
+// HandshakeError describes why an upgrade request was refused. Upgrade
+// has already answered the request when it returns one.
+type HandshakeError struct {
+	reason string
+}
+
+func (e HandshakeError) Error() string {
+	return "websocket: bad handshake: " + e.reason
+}
+
+// AcceptKey computes the Sec-WebSocket-Accept value for a client key.
+func AcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Upgrade checks the opening handshake, answers it and takes over the
+// connection from net/http.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	fail := func(status int, reason string) (*Conn, error) {
+		http.Error(w, reason, status)
+		return nil, HandshakeError{reason}
+	}
+	switch {
+	case r.Method != http.MethodGet:
+		return fail(http.StatusMethodNotAllowed, "method must be GET")
+	case !headerContains(r.Header, "Connection", "upgrade"):
+		return fail(http.StatusBadRequest, "missing Connection: upgrade")
+	case !headerContains(r.Header, "Upgrade", "websocket"):
+		return fail(http.StatusBadRequest, "missing Upgrade: websocket")
+	case r.Header.Get("Sec-Websocket-Version") != "13":
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail(http.StatusUpgradeRequired, "unsupported version")
+	}
+	key := r.Header.Get("Sec-Websocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return fail(http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return fail(http.StatusInternalServerError, "connection cannot be hijacked")
+	}
+	netConn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket: hijack: %w", err)
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n"
+	if _, err := netConn.Write([]byte(response)); err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("websocket: write handshake: %w", err)
+	}
+	return newConn(netConn, rw.Reader), nil
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Conn is a server-side WebSocket connection. One goroutine may read
+// while others write.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	// MaxMessageSize bounds a whole, possibly fragmented, message.
+	MaxMessageSize int
+
+	wmu    sync.Mutex
+	closed bool
+}
+
+func newConn(conn net.Conn, br *bufio.Reader) *Conn {
+	return &Conn{
+		conn:           conn,
+		br:             br,
+		MaxMessageSize: DefaultMaxMessageSize,
+	}
+}
+
+type frameHeader struct {
+	fin    bool
+	opcode Opcode
+	length uint64
+	mask   [4]byte
+}
+
+func (c *Conn) readHeader() (frameHeader, error) {
+	var hdr frameHeader
+	var b [8]byte
+	if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+		return hdr, err
+	}
+	hdr.fin = b[0]&0x80 != 0
+	if b[0]&0x70 != 0 {
+		return hdr, fmt.Errorf("%w: reserved bits set", ErrProtocol)
+	}
+	hdr.opcode = Opcode(b[0] & 0x0f)
+	if b[1]&0x80 == 0 {
+		return hdr, fmt.Errorf("%w: client frame not masked", ErrProtocol)
+	}
+
+	switch length := b[1] & 0x7f; length {
+	case 126:
+		if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+			return hdr, err
+		}
+		hdr.length = uint64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		if _, err := io.ReadFull(c.br, b[:8]); err != nil {
+			return hdr, err
+		}
+		hdr.length = binary.BigEndian.Uint64(b[:8])
+	default:
+		hdr.length = uint64(length)
+	}
+	if hdr.opcode.isControl() && (hdr.length > 125 || !hdr.fin) {
+		return hdr, fmt.Errorf("%w: bad control frame", ErrProtocol)
+	}
+
+	if _, err := io.ReadFull(c.br, hdr.mask[:]); err != nil {
+		return hdr, err
+	}
+	return hdr, nil
+}
+
+func (c *Conn) readPayload(hdr frameHeader) ([]byte, error) {
+	payload := make([]byte, hdr.length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return nil, err
+	}
+	for i := range payload {
+		payload[i] ^= hdr.mask[i%4]
+	}
+	return payload, nil
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered
+// and pongs skipped along the way. A close frame is answered and reported
+// as *CloseError; protocol violations close the connection with the
+// matching status.
+func (c *Conn) ReadMessage() (Opcode, []byte, error) {
+	var (
+		opcode  Opcode
+		message []byte
+		started bool
+	)
+	for {
+		hdr, err := c.readHeader()
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		if !hdr.opcode.isControl() && uint64(len(message))+hdr.length > uint64(c.MaxMessageSize) {
+			return 0, nil, c.fail(ErrMessageTooLarge)
+		}
+		payload, err := c.readPayload(hdr)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch hdr.opcode {
+		case OpPing:
+			if err := c.WriteMessage(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			return 0, nil, c.handleClose(payload)
+		case OpText, OpBinary:
+			if started {
+				return 0, nil, c.fail(fmt.Errorf("%w: expected continuation frame", ErrProtocol))
+			}
+			opcode, started = hdr.opcode, true
+		case OpContinuation:
+			if !started {
+				return 0, nil, c.fail(fmt.Errorf("%w: unexpected continuation frame", ErrProtocol))
+			}
+		default:
+			return 0, nil, c.fail(fmt.Errorf("%w: unknown opcode %d", ErrProtocol, hdr.opcode))
+		}
+
+		message = append(message, payload...)
+		if !hdr.fin {
+			continue
+		}
+		if opcode == OpText && !utf8.Valid(message) {
+			c.Close(CloseInvalidPayload, "invalid utf-8")
+			return 0, nil, fmt.Errorf("%w: invalid utf-8 in text message", ErrProtocol)
+		}
+		return opcode, message, nil
+	}
+}
+
+// handleClose answers a close frame with the same code, or with a protocol
+// error for codes that must not be sent.
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNormal}
+	switch {
+	case len(payload) == 1:
+		return c.fail(fmt.Errorf("%w: truncated close code", ErrProtocol))
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload[:2]))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(fmt.Errorf("%w: invalid close code %d", ErrProtocol, closeErr.Code))
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			c.Close(CloseInvalidPayload, "invalid utf-8")
+			return fmt.Errorf("%w: invalid utf-8 in close reason", ErrProtocol)
+		}
+	}
+	c.Close(closeErr.Code, "")
+	return closeErr
+}
+
+// validCloseCode reports whether a peer may send code: one defined by RFC
+// 6455 or registered since, or one for applications. 1005, 1006 and 1015
+// only ever describe a close locally.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code < 1000 || code > 1014:
+		return false
+	}
+	return code != 1004 && code != 1005 && code != 1006
+}
+
+// fail closes the connection with the status matching err and returns err.
+func (c *Conn) fail(err error) error {
+	switch {
+	case errors.Is(err, ErrMessageTooLarge):
+		c.Close(CloseMessageTooBig, "message too large")
+	case errors.Is(err, ErrProtocol):
+		c.Close(CloseProtocolError, "protocol error")
+	}
+	return err
+}
+
+// WriteMessage sends data as a single unfragmented frame.
+func (c *Conn) WriteMessage(opcode Opcode, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	return c.writeFrame(opcode, data)
+}
+
+func (c *Conn) writeFrame(opcode Opcode, data []byte) error {
+	frame := make([]byte, 0, len(data)+10)
+	frame = append(frame, 0x80|byte(opcode))
+	switch n := len(data); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, data...)
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame with code and reason, once, and closes the
+// underlying connection. The deadline is set before waiting for a write in
+// progress, so a peer that stopped reading cannot hold Close up.
+func (c *Conn) Close(code int, reason string) error {
+	deadline := time.Now().Add(closeTimeout)
+	c.conn.SetWriteDeadline(deadline)
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	c.conn.SetWriteDeadline(deadline)
+	c.writeFrame(OpClose, payload)
+	return c.conn.Close()
+}
+
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
@@ -0,0 +1,151 @@
+package websocket
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestAcceptKey tests the accept key against the example in RFC 6455
+func TestAcceptKey(t *testing.T) {
+	got := AcceptKey("dGhlIHNhbXBsZSBub25jZQ==")
+	want := "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+	if got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+// clientFrame builds a masked frame the way a browser sends it.
+func clientFrame(fin bool, opcode Opcode, payload []byte) []byte {
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{b0, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, c := range payload {
+		frame = append(frame, c^mask[i%4])
+	}
+	return frame
+}
+
+func pipeConn(t *testing.T) (*Conn, net.Conn) {
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return newConn(server, bufio.NewReader(server)), client
+}
+
+// TestReadMessage tests reassembly of fragments around an answered ping
+func TestReadMessage(t *testing.T) {
+	conn, client := pipeConn(t)
+	go func() {
+		client.Write(clientFrame(false, OpText, []byte("hel")))
+		client.Write(clientFrame(true, OpPing, []byte("p")))
+		client.Write(clientFrame(true, OpContinuation, []byte("lo")))
+	}()
+
+	pong := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 3)
+		io.ReadFull(client, buf)
+		pong <- buf
+	}()
+
+	opcode, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if opcode != OpText || string(msg) != "hello" {
+		t.Errorf("Expected text hello, got %d %q", opcode, msg)
+	}
+	if got := <-pong; got[0] != 0x80|byte(OpPong) || got[2] != 'p' {
+		t.Errorf("Expected pong with payload p, got %v", got)
+	}
+}
+
+// TestReadMessageTooLarge tests that oversized messages are refused before being buffered
+func TestReadMessageTooLarge(t *testing.T) {
+	conn, client := pipeConn(t)
+	conn.MaxMessageSize = 4
+	go client.Write(clientFrame(true, OpText, []byte("too long")))
+	go io.Copy(io.Discard, client)
+
+	_, _, err := conn.ReadMessage()
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Expected ErrMessageTooLarge, got %v", err)
+	}
+}
+
+// TestReadMessageUnmasked tests that unmasked client frames are a protocol error
+func TestReadMessageUnmasked(t *testing.T) {
+	conn, client := pipeConn(t)
+	go client.Write([]byte{0x81, 0x01, 'a'})
+	go io.Copy(io.Discard, client)
+
+	_, _, err := conn.ReadMessage()
+	if !errors.Is(err, ErrProtocol) {
+		t.Errorf("Expected ErrProtocol, got %v", err)
+	}
+}
+
+// TestHandleClose tests that valid close codes are echoed and codes a peer
+// must not send are answered with a protocol error
+func TestHandleClose(t *testing.T) {
+	tests := []struct {
+		payload []byte
+		reply   int
+	}{
+		{nil, CloseNormal},
+		{[]byte{0x03, 0xe9}, CloseGoingAway},
+		{[]byte{0x0f, 0xa0, 'b', 'y', 'e'}, 4000},
+		{[]byte{0x03}, CloseProtocolError},
+		{[]byte{0x03, 0xed}, CloseProtocolError},
+		{[]byte{0x03, 0xee}, CloseProtocolError},
+		{[]byte{0x03, 0xf7}, CloseProtocolError},
+		{[]byte{0x03, 0xe8, 0xff}, CloseInvalidPayload},
+	}
+	for _, tt := range tests {
+		conn, client := pipeConn(t)
+		go client.Write(clientFrame(true, OpClose, tt.payload))
+		reply := make(chan []byte, 1)
+		go func() {
+			buf, _ := io.ReadAll(client)
+			reply <- buf
+		}()
+
+		conn.ReadMessage()
+		got := <-reply
+		if len(got) < 4 || int(got[2])<<8|int(got[3]) != tt.reply {
+			t.Errorf("Expected close %d for %v, got %v", tt.reply, tt.payload, got)
+		}
+	}
+}
+
+// TestCloseStalledWrite tests that Close does not wait for a write to a
+// peer that stopped reading
+func TestCloseStalledWrite(t *testing.T) {
+	conn, _ := pipeConn(t)
+	conn.wmu.Lock()
+	go func() {
+		defer conn.wmu.Unlock()
+		conn.writeFrame(OpText, []byte("nobody reads this"))
+	}()
+
+	closed := make(chan struct{})
+	go func() {
+		conn.Close(CloseGoingAway, "")
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(3 * closeTimeout):
+		t.Fatal("Expected Close to return despite the stalled write")
+	}
+}